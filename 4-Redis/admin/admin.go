@@ -0,0 +1,246 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"goredis/cache"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+//	admin API : inspect and flush the catalog caches
+
+type Options struct {
+	// Token is expected as "Authorization: Bearer <token>".
+	Token string
+	// Families are the key families the API may read and delete, e.g.
+	// cache.ProductKeys; anything else in Redis is out of its reach.
+	Families []string
+	// AuditLength caps the audit stream (approximately).
+	AuditLength int64
+}
+
+type api struct {
+	redisClient redis.UniversalClient
+	options     Options
+	audit       auditLog
+}
+
+// Register adds the admin routes to router, e.g. app.Group("/admin"):
+//
+//	GET    /cache/keys?layer=&prefix=&limit=	keys with TTL, size and codec
+//	GET    /cache/value?key=					decoded value of a key
+//	DELETE /cache/keys?key= | prefix= | tag=	flush (tag: e.g. product:42), recorded in the audit log
+//	GET    /audit?count=						latest flushes
+//
+// Keys are listed and deleted with SCAN, never KEYS.
+func Register(router fiber.Router, redisClient redis.UniversalClient, options Options) error {
+	if options.Token == "" {
+		return errors.New("admin: a token is required")
+	}
+	if options.AuditLength <= 0 {
+		options.AuditLength = 10000
+	}
+	a := api{
+		redisClient: redisClient,
+		options:     options,
+		audit:       auditLog{redisClient: redisClient, length: options.AuditLength},
+	}
+
+	router.Use(a.authorize)
+	router.Get("/cache/keys", a.listKeys)
+	router.Get("/cache/value", a.getValue)
+	router.Delete("/cache/keys", a.deleteKeys)
+	router.Get("/audit", a.listAudit)
+	return nil
+}
+
+func (a api) authorize(c *fiber.Ctx) error {
+	token := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.options.Token)) != 1 {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="admin"`)
+		return fiber.ErrUnauthorized
+	}
+	return c.Next()
+}
+
+//	handlers
+
+// errStop ends a SCAN once the limit is reached.
+var errStop = errors.New("admin: stop scan")
+
+func (a api) listKeys(c *fiber.Ctx) error {
+	layer := c.Query("layer")
+	prefix := c.Query("prefix")
+	limit, err := intQuery(c, "limit", 100)
+	if err != nil {
+		return err
+	}
+
+	patterns := []string{}
+	for _, family := range a.options.Families {
+		if layer != "" && layerOf(family) != layer {
+			continue
+		}
+		switch {
+		case prefix == "":
+			patterns = append(patterns, cache.EscapePattern(family)+"*")
+		case prefix == family || strings.HasPrefix(prefix, family+"::"):
+			patterns = []string{cache.EscapePattern(prefix) + "*"}
+		case strings.HasPrefix(family, prefix):
+			patterns = append(patterns, cache.EscapePattern(family)+"*")
+		}
+	}
+	if len(patterns) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "no cache family matches this layer and prefix")
+	}
+
+	seen := map[string]bool{}
+	keys := []string{}
+	truncated := false
+	for _, pattern := range patterns {
+		err = cache.ScanKeys(c.UserContext(), a.redisClient, pattern, func(key string) error {
+			if seen[key] || !a.scoped(key) || (layer != "" && layerOf(key) != layer) {
+				return nil
+			}
+			if len(keys) == limit {
+				truncated = true
+				return errStop
+			}
+			seen[key] = true
+			keys = append(keys, key)
+			return nil
+		})
+		if errors.Is(err, errStop) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	sort.Strings(keys)
+
+	infos, err := cache.InspectKeys(c.UserContext(), a.redisClient, keys)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"status":    "ok",
+		"keys":      infos,
+		"truncated": truncated,
+	})
+}
+
+func (a api) getValue(c *fiber.Ctx) error {
+	key := c.Query("key")
+	if !a.scoped(key) {
+		return fiber.NewError(fiber.StatusBadRequest, "key is not in a cache family")
+	}
+
+	info, value, err := cache.DecodeKey(c.UserContext(), a.redisClient, key)
+	switch {
+	case errors.Is(err, redis.Nil):
+		return fiber.NewError(fiber.StatusNotFound, "no such key")
+	case errors.Is(err, cache.ErrNoDecoder):
+		return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+	case err != nil:
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"status": "ok",
+		"key":    info,
+		"value":  value,
+	})
+}
+
+func (a api) deleteKeys(c *fiber.Ctx) error {
+	key, prefix, tag := c.Query("key"), c.Query("prefix"), c.Query("tag")
+	entry := auditEntry{IP: c.IP()}
+	switch {
+	case key != "" && prefix == "" && tag == "":
+		entry.Action, entry.Target = "key", key
+	case prefix != "" && key == "" && tag == "":
+		entry.Action, entry.Target = "prefix", prefix
+	case tag != "" && key == "" && prefix == "":
+		entry.Action, entry.Target = "tag", tag
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "one of key, prefix or tag")
+	}
+	// a tag set only ever holds cache keys
+	if entry.Action != "tag" && !a.scoped(entry.Target) {
+		return fiber.NewError(fiber.StatusBadRequest, entry.Target+" is not in a cache family")
+	}
+
+	var err error
+	switch entry.Action {
+	case "key":
+		entry.Deleted, err = cache.FlushKey(c.UserContext(), a.redisClient, key)
+	case "prefix":
+		entry.Deleted, err = cache.FlushPrefix(c.UserContext(), a.redisClient, prefix)
+	case "tag":
+		entry.Deleted, err = cache.InvalidateTags(c.UserContext(), a.redisClient, tag)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	// partial flushes are recorded too
+	a.audit.record(entry)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "ok",
+		"deleted": entry.Deleted,
+	})
+}
+
+func (a api) listAudit(c *fiber.Ctx) error {
+	count, err := intQuery(c, "count", 50)
+	if err != nil {
+		return err
+	}
+
+	entries, err := a.audit.latest(c.UserContext(), int64(count))
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"status":  "ok",
+		"entries": entries,
+	})
+}
+
+//	helper
+
+// scoped tells whether key (or prefix) lies within one of the families.
+func (a api) scoped(key string) bool {
+	for _, family := range a.options.Families {
+		if key == family || strings.HasPrefix(key, family+"::") {
+			return true
+		}
+	}
+	return false
+}
+
+// intQuery reads a count of 1 to 1000, fallback when absent.
+func intQuery(c *fiber.Ctx, name string, fallback int) (int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 || n > 1000 {
+		return 0, fiber.NewError(fiber.StatusBadRequest, name+" is 1 to 1000")
+	}
+	return n, nil
+}
+
+func layerOf(key string) string {
+	layer, _, _ := strings.Cut(key, "::")
+	return layer
+}
@@ -0,0 +1,286 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"goredis/cache"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+const testToken = "secret"
+
+func newTestAPI(t *testing.T) (*fiber.App, redis.UniversalClient) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	app := fiber.New()
+	err := Register(app.Group("/admin"), redisClient, Options{
+		Token:    testToken,
+		Families: append(cache.ProductKeys, cache.ProductDetailKeys...),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app, redisClient
+}
+
+// call sends an authorized request and decodes the JSON answer into v (if
+// not nil).
+func call(t *testing.T, app *fiber.App, method, target string, v interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+testToken)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == fiber.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func set(t *testing.T, redisClient redis.UniversalClient, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if err := redisClient.Set(context.Background(), key, "value", 0).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type keyList struct {
+	Keys      []cache.KeyInfo `json:"keys"`
+	Truncated bool            `json:"truncated"`
+}
+
+func (l keyList) names() []string {
+	names := []string{}
+	for _, info := range l.Keys {
+		names = append(names, info.Key)
+	}
+	return names
+}
+
+func TestAuthorize(t *testing.T) {
+	app, _ := newTestAPI(t)
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "no token", wantStatus: fiber.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer wrong", wantStatus: fiber.StatusUnauthorized},
+		{name: "token prefix", authorization: "Bearer " + testToken[:3], wantStatus: fiber.StatusUnauthorized},
+		{name: "other scheme", authorization: "Basic " + testToken, wantStatus: fiber.StatusUnauthorized},
+		{name: "token", authorization: "Bearer " + testToken, wantStatus: fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, target := range []string{"/admin/cache/keys", "/admin/audit"} {
+				req := httptest.NewRequest("GET", target, nil)
+				if tt.authorization != "" {
+					req.Header.Set(fiber.HeaderAuthorization, tt.authorization)
+				}
+				resp, err := app.Test(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp.StatusCode != tt.wantStatus {
+					t.Fatalf("%v: status = %d, want %d", target, resp.StatusCode, tt.wantStatus)
+				}
+				if tt.wantStatus == fiber.StatusUnauthorized && resp.Header.Get(fiber.HeaderWWWAuthenticate) == "" {
+					t.Fatalf("%v: no WWW-Authenticate", target)
+				}
+			}
+		})
+	}
+}
+
+func TestFamilies(t *testing.T) {
+	app, redisClient := newTestAPI(t)
+	set(t, redisClient,
+		"service::GetProducts::limit=20",
+		"service::GetProduct::1",
+		"handler::GetProduct::1",
+		"service::GetProductRank::1", // same prefix, other family
+		"stock::quantity::1",
+		"ratelimit::writes::ip:1.2.3.4",
+	)
+
+	listTests := []struct {
+		target     string
+		wantStatus int
+		wantKeys   []string
+	}{
+		{target: "/admin/cache/keys", wantStatus: fiber.StatusOK, wantKeys: []string{"handler::GetProduct::1", "service::GetProduct::1", "service::GetProducts::limit=20"}},
+		{target: "/admin/cache/keys?layer=service", wantStatus: fiber.StatusOK, wantKeys: []string{"service::GetProduct::1", "service::GetProducts::limit=20"}},
+		{target: "/admin/cache/keys?prefix=service::GetProduct::", wantStatus: fiber.StatusOK, wantKeys: []string{"service::GetProduct::1"}},
+		{target: "/admin/cache/keys?layer=stock", wantStatus: fiber.StatusBadRequest},
+		{target: "/admin/cache/keys?prefix=ratelimit", wantStatus: fiber.StatusBadRequest},
+	}
+	for _, tt := range listTests {
+		list := keyList{}
+		if status := call(t, app, "GET", tt.target, &list); status != tt.wantStatus {
+			t.Fatalf("GET %v: status = %d, want %d", tt.target, status, tt.wantStatus)
+		}
+		if tt.wantStatus == fiber.StatusOK && !equal(list.names(), tt.wantKeys) {
+			t.Fatalf("GET %v: keys = %v, want %v", tt.target, list.names(), tt.wantKeys)
+		}
+	}
+
+	// out of reach: not read, not deleted
+	for _, target := range []string{
+		"/admin/cache/value?key=stock::quantity::1",
+		"/admin/cache/value?key=service::GetProductRank::1",
+	} {
+		if status := call(t, app, "GET", target, nil); status != fiber.StatusBadRequest {
+			t.Fatalf("GET %v: status = %d, want 400", target, status)
+		}
+	}
+	for _, target := range []string{
+		"/admin/cache/keys?key=stock::quantity::1",
+		"/admin/cache/keys?prefix=ratelimit",
+		"/admin/cache/keys?prefix=service",
+		"/admin/cache/keys?prefix=service::GetProductRank",
+	} {
+		if status := call(t, app, "DELETE", target, nil); status != fiber.StatusBadRequest {
+			t.Fatalf("DELETE %v: status = %d, want 400", target, status)
+		}
+	}
+	n, err := redisClient.Exists(context.Background(), "stock::quantity::1", "ratelimit::writes::ip:1.2.3.4", "service::GetProductRank::1").Result()
+	if err != nil || n != 3 {
+		t.Fatalf("%d keys out of the families left, want 3 (%v)", n, err)
+	}
+}
+
+func TestListKeysLimit(t *testing.T) {
+	app, redisClient := newTestAPI(t)
+	for i := 1; i <= 250; i++ {
+		set(t, redisClient, cache.ProductKey(cache.ServiceGetProduct, i))
+	}
+
+	tests := []struct {
+		target        string
+		wantStatus    int
+		wantKeys      int
+		wantTruncated bool
+	}{
+		{target: "/admin/cache/keys", wantStatus: fiber.StatusOK, wantKeys: 100, wantTruncated: true},
+		{target: "/admin/cache/keys?limit=249", wantStatus: fiber.StatusOK, wantKeys: 249, wantTruncated: true},
+		{target: "/admin/cache/keys?limit=250", wantStatus: fiber.StatusOK, wantKeys: 250},
+		{target: "/admin/cache/keys?limit=1000", wantStatus: fiber.StatusOK, wantKeys: 250},
+		{target: "/admin/cache/keys?limit=0", wantStatus: fiber.StatusBadRequest},
+		{target: "/admin/cache/keys?limit=1001", wantStatus: fiber.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		list := keyList{}
+		if status := call(t, app, "GET", tt.target, &list); status != tt.wantStatus {
+			t.Fatalf("GET %v: status = %d, want %d", tt.target, status, tt.wantStatus)
+		}
+		if len(list.Keys) != tt.wantKeys || list.Truncated != tt.wantTruncated {
+			t.Fatalf("GET %v: %d keys (truncated %v), want %d (truncated %v)",
+				tt.target, len(list.Keys), list.Truncated, tt.wantKeys, tt.wantTruncated)
+		}
+	}
+}
+
+func TestDeleteAudit(t *testing.T) {
+	app, redisClient := newTestAPI(t)
+	ctx := context.Background()
+	set(t, redisClient,
+		"service::GetProduct::1",
+		"service::GetProduct::2",
+		"handler::GetProducts::limit=20",
+		"handler::GetProducts::limit=50",
+		"repository::GetProducts::limit=20",
+	)
+	tag := cache.ProductTag(2)
+	if err := redisClient.SAdd(ctx, cache.Tag(cache.Key("tag", tag)), "service::GetProduct::2").Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target      string
+		wantStatus  int
+		wantDeleted int64
+		wantAction  string // of the audit entry, none when empty
+	}{
+		{target: "/admin/cache/keys?key=service::GetProduct::1", wantStatus: fiber.StatusOK, wantDeleted: 1, wantAction: "key"},
+		{target: "/admin/cache/keys?prefix=handler::GetProducts", wantStatus: fiber.StatusOK, wantDeleted: 2, wantAction: "prefix"},
+		{target: "/admin/cache/keys?tag=" + tag, wantStatus: fiber.StatusOK, wantDeleted: 1, wantAction: "tag"},
+		{target: "/admin/cache/keys?key=service::GetProduct::9", wantStatus: fiber.StatusOK, wantAction: "key"},
+		{target: "/admin/cache/keys", wantStatus: fiber.StatusBadRequest},
+		{target: "/admin/cache/keys?key=service::GetProduct::1&prefix=service::GetProduct", wantStatus: fiber.StatusBadRequest},
+		{target: "/admin/cache/keys?key=stock::quantity::1", wantStatus: fiber.StatusBadRequest},
+	}
+
+	audited := 0
+	for _, tt := range tests {
+		answer := struct {
+			Deleted int64 `json:"deleted"`
+		}{}
+		if status := call(t, app, "DELETE", tt.target, &answer); status != tt.wantStatus {
+			t.Fatalf("DELETE %v: status = %d, want %d", tt.target, status, tt.wantStatus)
+		}
+		if answer.Deleted != tt.wantDeleted {
+			t.Fatalf("DELETE %v: deleted = %d, want %d", tt.target, answer.Deleted, tt.wantDeleted)
+		}
+
+		audit := struct {
+			Entries []auditEntry `json:"entries"`
+		}{}
+		if status := call(t, app, "GET", "/admin/audit", &audit); status != fiber.StatusOK {
+			t.Fatalf("GET /admin/audit: status = %d", status)
+		}
+		if tt.wantAction != "" {
+			audited++
+		}
+		if len(audit.Entries) != audited {
+			t.Fatalf("DELETE %v: %d audit entries, want %d", tt.target, len(audit.Entries), audited)
+		}
+		if tt.wantAction == "" {
+			continue
+		}
+		// newest first
+		latest := audit.Entries[0]
+		if latest.Action != tt.wantAction || latest.Deleted != tt.wantDeleted || latest.Time.IsZero() {
+			t.Fatalf("DELETE %v: audit entry = %+v, want %v of %d keys", tt.target, latest, tt.wantAction, tt.wantDeleted)
+		}
+	}
+
+	if n, _ := redisClient.Exists(ctx, "repository::GetProducts::limit=20").Result(); n != 1 {
+		t.Fatal("a key no flush named was deleted")
+	}
+	audit := struct {
+		Entries []auditEntry `json:"entries"`
+	}{}
+	if status := call(t, app, "GET", "/admin/audit?count=2", &audit); status != fiber.StatusOK || len(audit.Entries) != 2 {
+		t.Fatalf("GET /admin/audit?count=2: status %d, %d entries", status, len(audit.Entries))
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+//	audit log : every flush, in a capped Redis Stream shared by the instances
+
+const AuditStream = "admin::audit"
+
+type auditEntry struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	IP      string    `json:"ip"`
+	Action  string    `json:"action"` // key, prefix or tag
+	Target  string    `json:"target"`
+	Deleted int64     `json:"deleted"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type auditLog struct {
+	redisClient redis.UniversalClient
+	length      int64
+}
+
+// record logs the flush and appends it to the stream. It runs once the keys
+// are gone, so a failure is logged rather than returned, with a context of
+// its own: the request deadline may be spent by then.
+func (l auditLog) record(entry auditEntry) {
+	entry.Time = time.Now().UTC()
+	log.Printf("admin: flush %v %q from %v: %v keys deleted %v", entry.Action, entry.Target, entry.IP, entry.Deleted, entry.Error)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := l.redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: AuditStream,
+		MaxLen: l.length,
+		Approx: true,
+		Values: map[string]interface{}{
+			"time":    entry.Time.Format(time.RFC3339Nano),
+			"ip":      entry.IP,
+			"action":  entry.Action,
+			"target":  entry.Target,
+			"deleted": entry.Deleted,
+			"error":   entry.Error,
+		},
+	}).Err()
+	if err != nil {
+		log.Println("admin: audit:", err)
+	}
+}
+
+// latest returns the last count entries, newest first.
+func (l auditLog) latest(ctx context.Context, count int64) ([]auditEntry, error) {
+	messages, err := l.redisClient.XRevRangeN(ctx, AuditStream, "+", "-", count).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]auditEntry, 0, len(messages))
+	for _, message := range messages {
+		entry := auditEntry{ID: message.ID}
+		entry.Time, _ = time.Parse(time.RFC3339Nano, field(message, "time"))
+		entry.IP = field(message, "ip")
+		entry.Action = field(message, "action")
+		entry.Target = field(message, "target")
+		entry.Deleted, _ = strconv.ParseInt(field(message, "deleted"), 10, 64)
+		entry.Error = field(message, "error")
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func field(message redis.XMessage, name string) string {
+	value, _ := message.Values[name].(string)
+	return value
+}
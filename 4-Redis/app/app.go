@@ -0,0 +1,261 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"goredis/admin"
+	"goredis/cache"
+	"goredis/config"
+	"goredis/handlers"
+	"goredis/metrics"
+	"goredis/ratelimit"
+	"goredis/repositories"
+	"goredis/services"
+	"goredis/tracing"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/expvar"
+	"gorm.io/gorm"
+)
+
+// New wires repository -> service -> handler, putting the redis decorator on
+// the layers chosen by cfg.Cache.Layer, and registers the routes. It starts
+// the cache warm-up (GET /readyz is 503 until it is done, or while a required
+// dependency is down); background work (migration retries, L1
+// invalidation, scheduled refresh, tag pruning, Bloom filter rebuilds) stops
+// when ctx is done.
+func New(ctx context.Context, cfg config.Config, db *gorm.DB, redisClient redis.UniversalClient) (*fiber.App, error) {
+
+	layers, err := cfg.Cache.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := cfg.Cache.Format()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := cache.Settings{
+		TTL:          cfg.Cache.TTL,
+		Stale:        cfg.Cache.Stale,
+		EarlyRefresh: cfg.Cache.EarlyRefresh,
+		Format:       format,
+		MissingTTL:   cfg.Cache.MissingTTL,
+	}
+	if len(layers) > 0 && cfg.Cache.L1.Size > 0 {
+		settings.Local = cache.NewLocal(cfg.Cache.L1.Size, cfg.Cache.L1.TTL)
+		go settings.Local.Listen(ctx, redisClient)
+	}
+	if len(layers) > 0 && cfg.Cache.TagPruneInterval > 0 {
+		go cache.PruneTagsEvery(ctx, redisClient, cfg.Cache.TagPruneInterval)
+	}
+
+	warm := newWarmup(cfg.Cache.Warmup)
+
+	withBloom := len(layers) > 0 && cfg.Cache.Bloom.Enabled
+	bloom := repositories.NewProductBloom(redisClient, cfg.Cache.Bloom.Capacity, cfg.Cache.Bloom.FalsePositiveRate)
+
+	//	built from the table : when the database is down at startup, once
+	//	it is migrated
+	productRepo := repositories.NewProductRepositoryDB(ctx, db, func(ctx context.Context) {
+		if withBloom {
+			if err := initBloom(ctx, db, bloom); err != nil {
+				//	not fatal : without a filter every id goes to the database
+				log.Println("bloom: rebuild:", err)
+			}
+			if cfg.Cache.Bloom.RebuildInterval > 0 {
+				go rebuildBloomEvery(ctx, db, bloom, cfg.Cache.Bloom.RebuildInterval)
+			}
+		}
+		if layers[config.LayerLeaderboard] {
+			if err := initLeaderboard(ctx, db, redisClient); err != nil {
+				//	not fatal : the adapter reads the database until it is rebuilt
+				log.Println("leaderboard: rebuild:", err)
+			}
+		}
+	})
+	if withBloom {
+		productRepo = repositories.NewProductRepositoryBloom(productRepo, bloom)
+	}
+	if layers[config.LayerLeaderboard] {
+		productRepo = repositories.NewProductRepositoryLeaderboard(productRepo, redisClient)
+	}
+	if layers[config.LayerRepository] {
+		productRepo = repositories.NewProductRepositoryRedis(productRepo, redisClient, settings)
+		warm.add(productRepo)
+	}
+
+	//	spans of the repository calls, cache decorators included
+	productRepo = repositories.NewProductRepositoryTracing(productRepo)
+
+	//	on top so every write also resets the reservation stock
+	stockRepo := repositories.NewProductRepositoryStock(productRepo, db, redisClient)
+
+	productService := services.NewCatalogService(stockRepo)
+	if layers[config.LayerService] {
+		productService = services.NewCatalogServiceRedis(productService, redisClient, settings)
+		warm.add(productService)
+	}
+	productService = services.NewCatalogServiceTracing(productService)
+
+	productHandler := handlers.NewCatalogHandler(productService)
+	if layers[config.LayerHandler] {
+		productHandler = handlers.NewCatalogHanlderRedis(productService, redisClient, settings)
+		warm.add(productHandler)
+	}
+
+	var stockQueue repositories.StockChangeQueue
+	if cfg.Inventory.WriteBehind.Enabled {
+		stockQueue = repositories.NewStockChangeQueueRedis(redisClient)
+	}
+	inventoryService := services.NewInventoryService(stockRepo, stockQueue, cfg.Inventory.ReservationTTL)
+	inventoryHandler := handlers.NewInventoryHandler(inventoryService)
+
+	log.Printf("cache layers: %v (ttl %v, stale %v, l1 %v, codec %v/%v)",
+		cfg.Cache.Layer, settings.TTL, settings.Stale, cfg.Cache.L1.Size, format.Codec, format.Compression)
+
+	app := fiber.New()
+	app.Use(tracing.Middleware())
+	app.Use(metrics.Middleware())
+	app.Use(expvar.New())
+	app.Get("/metrics", metrics.Handler())
+	health := newHealth(cfg.Health, db, redisClient, warm)
+	app.Get("/healthz", health.Live)
+	app.Get("/readyz", health.Ready)
+	if cfg.Admin.Token != "" {
+		//	before the request deadline : a scan of a large keyspace takes longer
+		err := admin.Register(app.Group("/admin", handlers.Deadline(cfg.Admin.Timeout)), redisClient, admin.Options{
+			Token:       cfg.Admin.Token,
+			Families:    append(cache.ProductKeys, cache.ProductDetailKeys...),
+			AuditLength: cfg.Admin.AuditLength,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	app.Use(handlers.Deadline(cfg.App.RequestTimeout))
+	if cfg.RateLimit.Enabled {
+		limiter, err := ratelimit.New(redisClient, rateLimitOptions(cfg.RateLimit))
+		if err != nil {
+			return nil, err
+		}
+		app.Use(limiter)
+	}
+
+	app.Get("/products", productHandler.GetProducts)
+	app.Get("/categories/:id/products", productHandler.GetCategoryProducts)
+	app.Get("/products/:id", productHandler.GetProduct)
+	app.Get("/products/:id/rank", productHandler.GetProductRank)
+	app.Post("/products", productHandler.CreateProduct)
+	app.Put("/products/:id", productHandler.UpdateProduct)
+	app.Patch("/products/:id", productHandler.PatchProduct)
+	app.Delete("/products/:id", productHandler.DeleteProduct)
+
+	app.Post("/products/:id/reserve", inventoryHandler.Reserve)
+	app.Post("/products/:id/release", inventoryHandler.Release)
+	app.Post("/products/:id/commit", inventoryHandler.Commit)
+	app.Post("/products/:id/stock", inventoryHandler.ChangeStock)
+
+	warm.start(ctx)
+	if cfg.Inventory.WriteBehind.Enabled {
+		startStockWriter(app, stockRepo, redisClient, cfg.Inventory.WriteBehind)
+	}
+
+	return app, nil
+}
+
+// rebuildBloomEvery runs initBloom every interval until ctx is done.
+func rebuildBloomEvery(ctx context.Context, db *gorm.DB, bloom cache.Bloom, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := initBloom(ctx, db, bloom); err != nil {
+				log.Println("bloom: rebuild:", err)
+			}
+		}
+	}
+}
+
+// startStockWriter runs the write-behind writer until the app shuts down;
+// app.Shutdown returns once the writer has drained what was queued.
+func startStockWriter(app *fiber.App, productRepo repositories.ProductRepository, redisClient redis.UniversalClient, cfg config.WriteBehindConfig) {
+	writer := repositories.NewStockChangeWriter(productRepo, redisClient, repositories.StockChangeWriterOptions{
+		Group:         cfg.Group,
+		BatchSize:     cfg.BatchSize,
+		FlushInterval: cfg.FlushInterval,
+		Retries:       cfg.Retries,
+		ClaimIdle:     cfg.ClaimIdle,
+		DrainTimeout:  cfg.DrainTimeout,
+	})
+
+	//	not the app ctx : requests still in flight at shutdown may queue changes
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		writer.Run(ctx)
+		close(done)
+	}()
+
+	app.Hooks().OnShutdown(func() error {
+		stop()
+		<-done
+		return nil
+	})
+}
+
+func rateLimitOptions(cfg config.RateLimitConfig) ratelimit.Options {
+	options := ratelimit.Options{
+		FailClosed: cfg.FailClosed,
+		KeyOptions: ratelimit.KeyOptions{APIKeyHeader: cfg.APIKeyHeader, APIKeys: cfg.APIKeys, JWTSecret: cfg.JWTSecret},
+	}
+	for _, rule := range cfg.Rules {
+		options.Rules = append(options.Rules, ratelimit.Rule{
+			Name:   rule.Name,
+			Method: rule.Method,
+			Path:   rule.Path,
+			Key:    ratelimit.KeyKind(rule.Key),
+			Limit:  rule.Limit,
+			Window: rule.Window,
+			Burst:  rule.Burst,
+		})
+	}
+	return options
+}
+
+// initLeaderboard rebuilds the ZSET at startup; after that the writes keep
+// it in sync (re-sync with go run ./cmd/leaderboard). An existing ZSET is
+// rebuilt too: writes of instances without the layer, or whose save failed,
+// are not in it. When another instance is rebuilding it already, that one
+// does.
+func initLeaderboard(ctx context.Context, db *gorm.DB, redisClient redis.UniversalClient) error {
+	count, err := repositories.RebuildLeaderboard(ctx, db, redisClient)
+	if errors.Is(err, repositories.ErrLeaderboardRebuilding) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("leaderboard: ranked %v products", count)
+	return nil
+}
+
+// initBloom fills the filter of product ids from the table. CreateProduct
+// adds the new ids, but not on another instance without the filter, nor when
+// redis fails (it drops the filter then), so an existing filter is rebuilt
+// too rather than trusted.
+func initBloom(ctx context.Context, db *gorm.DB, bloom cache.Bloom) error {
+	count, err := repositories.RebuildProductBloom(ctx, db, bloom)
+	if err != nil {
+		return err
+	}
+	log.Printf("bloom: %v product ids in %v", count, bloom.Key())
+	return nil
+}
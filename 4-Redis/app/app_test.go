@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"goredis/config"
+	"goredis/repositories"
+	"goredis/services"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestApp runs the whole app, every cache layer and the leaderboard on,
+// on a sqlite database and a miniredis. Entries never expire on their own:
+// only an invalidation makes a read fresh.
+func newTestApp(t *testing.T) (*fiber.App, *miniredis.Miniredis) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one connection: every connection to :memory: is a new database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	cfg, err := config.Load([]string{"--cache-layer=all,leaderboard"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Cache.TTL, cfg.Cache.Stale = time.Hour, time.Hour
+	cfg.Cache.Warmup.Enabled = false
+	cfg.RateLimit.Enabled = false
+	cfg.Inventory.WriteBehind.FlushInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	server, err := New(ctx, cfg, db, redisClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		server.Shutdown()
+	})
+	return server, mr
+}
+
+// send makes a request with a JSON body (if not empty) and decodes a
+// successful answer into v.
+func send(t *testing.T, app *fiber.App, method, target, body string, v interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+type listing struct {
+	Products []services.Product `json:"products"`
+	Paging   services.Paging    `json:"paging"`
+}
+
+type detail struct {
+	Product services.Product `json:"product"`
+}
+
+// top returns the first page of the default listing, the one every cache
+// layer and the leaderboard hold.
+func top(t *testing.T, app *fiber.App) listing {
+	t.Helper()
+	page := listing{}
+	if status := send(t, app, "GET", "/products", "", &page); status != fiber.StatusOK {
+		t.Fatalf("GET /products: status = %d", status)
+	}
+	return page
+}
+
+// product returns product id, or nothing when it is not found.
+func product(t *testing.T, app *fiber.App, id int) (services.Product, bool) {
+	t.Helper()
+	p := detail{}
+	status := send(t, app, "GET", "/products/"+strconv.Itoa(id), "", &p)
+	if status != fiber.StatusOK && status != fiber.StatusNotFound {
+		t.Fatalf("GET /products/%d: status = %d", id, status)
+	}
+	return p.Product, status == fiber.StatusOK
+}
+
+func TestWritesInvalidate(t *testing.T) {
+	app, mr := newTestApp(t)
+
+	//	create : on top of the listing
+	total := top(t, app).Paging.Total
+	for _, layer := range []string{"repository::", "service::", "handler::"} {
+		if !hasPrefix(mr.Keys(), layer+"GetProducts::") {
+			t.Fatalf("listing not cached in %v: %v", layer, mr.Keys())
+		}
+	}
+	created := detail{}
+	status := send(t, app, "POST", "/products", `{"name":"Fresh","quantity":100000,"sku":"FRESH-1"}`, &created)
+	if status != fiber.StatusCreated {
+		t.Fatalf("POST /products: status = %d", status)
+	}
+	id := created.Product.ID
+	if page := top(t, app); page.Paging.Total != total+1 || page.Products[0].ID != id {
+		t.Fatalf("after the create: first product %d of %d, want %d of %d", page.Products[0].ID, page.Paging.Total, id, total+1)
+	}
+
+	//	update : the detail and the listing it moves out of
+	if p, ok := product(t, app, id); !ok || p.Quantity != 100000 {
+		t.Fatalf("GET /products/%d = %+v, %v", id, p, ok)
+	}
+	if status := send(t, app, "PATCH", "/products/"+strconv.Itoa(id), `{"quantity":0}`, nil); status != fiber.StatusOK {
+		t.Fatalf("PATCH: status = %d", status)
+	}
+	if p, _ := product(t, app, id); p.Quantity != 0 {
+		t.Fatalf("after the update: quantity = %d, want 0", p.Quantity)
+	}
+	if page := top(t, app); page.Products[0].ID == id {
+		t.Fatal("after the update: still first")
+	}
+
+	//	stock change : applied by the write-behind writer
+	if status := send(t, app, "POST", "/products/"+strconv.Itoa(id)+"/stock", `{"delta":200000}`, nil); status != fiber.StatusAccepted {
+		t.Fatalf("POST stock: status = %d", status)
+	}
+	waitFlushed(t, mr)
+	if p, _ := product(t, app, id); p.Quantity != 200000 {
+		t.Fatalf("after the stock change: quantity = %d, want 200000", p.Quantity)
+	}
+	if page := top(t, app); page.Products[0].ID != id || page.Products[0].Quantity != 200000 {
+		t.Fatalf("after the stock change: first product %+v, want %d", page.Products[0], id)
+	}
+
+	//	delete : gone from the detail and the listing
+	if status := send(t, app, "DELETE", "/products/"+strconv.Itoa(id), "", nil); status != fiber.StatusNoContent {
+		t.Fatalf("DELETE: status = %d", status)
+	}
+	if _, ok := product(t, app, id); ok {
+		t.Fatal("after the delete: still found")
+	}
+	if page := top(t, app); page.Paging.Total != total || page.Products[0].ID == id {
+		t.Fatalf("after the delete: first product %d of %d, want another of %d", page.Products[0].ID, page.Paging.Total, total)
+	}
+}
+
+// waitFlushed returns once the writer has applied, and so invalidated, the
+// queued stock changes: it deletes them from the stream after that.
+func waitFlushed(t *testing.T, mr *miniredis.Miniredis) {
+	t.Helper()
+	for {
+		entries, err := mr.Stream(repositories.StockChangeStream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func hasPrefix(keys []string, prefix string) bool {
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"goredis/config"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+//	health
+
+// dependency is something the app needs to serve requests, checked by
+// GET /readyz. An optional one is reported but does not make the app
+// unready: without Redis every layer reads the database.
+type dependency struct {
+	name     string
+	optional bool
+	ping     func(ctx context.Context) error
+}
+
+// checkResult is one dependency in the GET /readyz body.
+type checkResult struct {
+	Status    string  `json:"status"` // up | down
+	Required  bool    `json:"required"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type health struct {
+	dependencies []dependency
+	warmup       *warmup
+	timeout      time.Duration
+}
+
+func newHealth(cfg config.HealthConfig, db *gorm.DB, redisClient redis.UniversalClient, warm *warmup) *health {
+	return &health{
+		dependencies: []dependency{
+			{name: "database", ping: func(ctx context.Context) error {
+				sqlDB, err := db.DB()
+				if err != nil {
+					return err
+				}
+				return sqlDB.PingContext(ctx)
+			}},
+			{name: "redis", optional: cfg.RedisOptional, ping: func(ctx context.Context) error {
+				return redisClient.Ping(ctx).Err()
+			}},
+		},
+		warmup:  warm,
+		timeout: cfg.Timeout,
+	}
+}
+
+// Live answers GET /healthz: the process is up and serving. It checks
+// nothing else, a restart would not bring a dependency back.
+func (h *health) Live(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// Ready answers GET /readyz: every dependency is pinged (in parallel, each
+// within the timeout) and the warm-up must be done. It is 503 while a
+// required dependency is down or the caches are warming up.
+func (h *health) Ready(c *fiber.Ctx) error {
+	checks := h.check(c.UserContext())
+
+	ready := true
+	for _, dep := range h.dependencies {
+		if checks[dep.name].Status != "up" && !dep.optional {
+			ready = false
+		}
+	}
+
+	status, code := "ok", fiber.StatusOK
+	switch {
+	case !ready:
+		status, code = "unavailable", fiber.StatusServiceUnavailable
+	case !h.warmup.ready.Load():
+		status, code = "warming up", fiber.StatusServiceUnavailable
+	}
+	return c.Status(code).JSON(fiber.Map{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+func (h *health) check(ctx context.Context) map[string]checkResult {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	checks := map[string]checkResult{}
+	for _, dep := range h.dependencies {
+		wg.Add(1)
+		go func(dep dependency) {
+			defer wg.Done()
+			start := time.Now()
+			err := dep.ping(ctx)
+			result := checkResult{
+				Status:    "up",
+				Required:  !dep.optional,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status, result.Error = "down", err.Error()
+			}
+			mu.Lock()
+			checks[dep.name] = result
+			mu.Unlock()
+		}(dep)
+	}
+	wg.Wait()
+	return checks
+}
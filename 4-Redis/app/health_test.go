@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"goredis/config"
+	"goredis/repositories"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// readiness is the GET /readyz body.
+type readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// newTestHealth serves GET /readyz for a sqlite database and a miniredis,
+// closed when dbDown and redisDown.
+func newTestHealth(t *testing.T, cfg config.HealthConfig, warm *warmup, dbDown, redisDown bool) *fiber.App {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if dbDown {
+		sqlDB.Close()
+	}
+
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { redisClient.Close() })
+	if redisDown {
+		mr.Close()
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+	app := fiber.New()
+	app.Get("/readyz", newHealth(cfg, db, redisClient, warm).Ready)
+	return app
+}
+
+func ready(t *testing.T, app *fiber.App) (int, readiness) {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest("GET", "/readyz", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body := readiness{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+func TestReadyDependencies(t *testing.T) {
+	tests := []struct {
+		name          string
+		redisOptional bool
+		dbDown        bool
+		redisDown     bool
+		wantStatus    int
+		wantBody      string
+		wantDown      string // the dependency reported down
+	}{
+		{name: "all up", wantStatus: fiber.StatusOK, wantBody: "ok"},
+		{name: "database down", dbDown: true, wantStatus: fiber.StatusServiceUnavailable, wantBody: "unavailable", wantDown: "database"},
+		{name: "database down, redis optional", redisOptional: true, dbDown: true, wantStatus: fiber.StatusServiceUnavailable, wantBody: "unavailable", wantDown: "database"},
+		{name: "redis down", redisDown: true, wantStatus: fiber.StatusServiceUnavailable, wantBody: "unavailable", wantDown: "redis"},
+		{name: "redis down, optional", redisOptional: true, redisDown: true, wantStatus: fiber.StatusOK, wantBody: "ok", wantDown: "redis"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warm := newWarmup(config.WarmupConfig{})
+			warm.start(context.Background())
+			app := newTestHealth(t, config.HealthConfig{RedisOptional: tt.redisOptional}, warm, tt.dbDown, tt.redisDown)
+
+			status, body := ready(t, app)
+			if status != tt.wantStatus || body.Status != tt.wantBody {
+				t.Fatalf("GET /readyz = %d %q, want %d %q", status, body.Status, tt.wantStatus, tt.wantBody)
+			}
+			for _, name := range []string{"database", "redis"} {
+				check, ok := body.Checks[name]
+				if !ok {
+					t.Fatalf("no %v check", name)
+				}
+				wantStatus := "up"
+				if name == tt.wantDown {
+					wantStatus = "down"
+				}
+				if check.Status != wantStatus || (wantStatus == "down") != (check.Error != "") {
+					t.Fatalf("%v = %+v, want %v", name, check, wantStatus)
+				}
+			}
+			if body.Checks["redis"].Required == tt.redisOptional {
+				t.Fatalf("redis required = %v, want %v", body.Checks["redis"].Required, !tt.redisOptional)
+			}
+		})
+	}
+}
+
+// blockedWarmer loads nothing until released.
+type blockedWarmer struct {
+	release chan struct{}
+	loads   int32
+}
+
+func (w *blockedWarmer) WarmProducts(ctx context.Context, query repositories.ProductQuery) error {
+	<-w.release
+	atomic.AddInt32(&w.loads, 1)
+	return nil
+}
+
+func TestReadyWarmup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	warmer := &blockedWarmer{release: make(chan struct{})}
+	warm := newWarmup(config.WarmupConfig{Enabled: true, Pages: 2, Timeout: time.Minute})
+	warm.add(warmer)
+	warm.add("not a cache layer")
+	app := newTestHealth(t, config.HealthConfig{}, warm, false, false)
+
+	warm.start(ctx)
+	if status, body := ready(t, app); status != fiber.StatusServiceUnavailable || body.Status != "warming up" {
+		t.Fatalf("during the warm-up: GET /readyz = %d %q, want 503 warming up", status, body.Status)
+	}
+
+	close(warmer.release)
+	for !warm.ready.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	if status, body := ready(t, app); status != fiber.StatusOK || body.Status != "ok" {
+		t.Fatalf("after the warm-up: GET /readyz = %d %q, want 200 ok", status, body.Status)
+	}
+	if loads := atomic.LoadInt32(&warmer.loads); loads != 2 {
+		t.Fatalf("%d listings warmed, want 2", loads)
+	}
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"goredis/config"
+	"goredis/repositories"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+//	warm-up
+
+// productWarmer is a cache decorator (repository, service or handler) that
+// can load a listing before anyone asks for it.
+type productWarmer interface {
+	WarmProducts(ctx context.Context, query repositories.ProductQuery) error
+}
+
+// warmup fills the caches at startup and, optionally, reloads them on a
+// schedule. Warmers are kept innermost first, so each layer loads from one
+// that is already warm.
+type warmup struct {
+	warmers []productWarmer
+	queries []repositories.ProductQuery
+	cfg     config.WarmupConfig
+	ready   atomic.Bool
+}
+
+func newWarmup(cfg config.WarmupConfig) *warmup {
+	w := &warmup{cfg: cfg}
+	for page := 0; page < cfg.Pages; page++ {
+		w.queries = append(w.queries, repositories.ProductQuery{Offset: page * repositories.DefaultLimit}.Normalize())
+	}
+	for _, q := range cfg.Queries {
+		w.queries = append(w.queries, repositories.ProductQuery{
+			Limit:       q.Limit,
+			Offset:      q.Offset,
+			Sort:        q.Sort,
+			Order:       q.Order,
+			NamePrefix:  q.Name,
+			MinQuantity: q.MinQuantity,
+			MaxQuantity: q.MaxQuantity,
+			CategoryID:  q.Category,
+		}.Normalize())
+	}
+	return w
+}
+
+// add registers layer if it is a cache decorator.
+func (w *warmup) add(layer interface{}) {
+	if warmer, ok := layer.(productWarmer); ok {
+		w.warmers = append(w.warmers, warmer)
+	}
+}
+
+// start warms the caches in the background and then marks the app ready;
+// with a refresh interval it keeps reloading them until ctx is done.
+func (w *warmup) start(ctx context.Context) {
+	if !w.cfg.Enabled || len(w.warmers) == 0 || len(w.queries) == 0 {
+		w.ready.Store(true)
+		return
+	}
+
+	go func() {
+		start := time.Now()
+		failed := w.warm(ctx, w.cfg.Timeout)
+		log.Printf("cache: warm-up of %v listings in %v layers done in %v (%v failed)",
+			len(w.queries), len(w.warmers), time.Since(start).Round(time.Millisecond), failed)
+		w.ready.Store(true)
+
+		if w.cfg.RefreshInterval <= 0 {
+			return
+		}
+		ticker := time.NewTicker(w.cfg.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.warm(ctx, w.cfg.RefreshInterval)
+			}
+		}
+	}()
+}
+
+// warm loads every query into every layer and returns how many loads
+// failed. A failure is logged and skipped: the request will load it.
+func (w *warmup) warm(ctx context.Context, timeout time.Duration) (failed int) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for _, warmer := range w.warmers {
+		for _, query := range w.queries {
+			if err := warmer.WarmProducts(ctx, query); err != nil {
+				failed++
+				log.Printf("cache: warm %v: %v", query.CacheKey(), err)
+			}
+		}
+	}
+	return failed
+}
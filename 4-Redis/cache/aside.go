@@ -0,0 +1,524 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"goredis/metrics"
+	"goredis/tracing"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+)
+
+//	error policy
+
+// ErrorPolicy decides what a Redis failure means for the caller.
+type ErrorPolicy int
+
+const (
+	// FailOpen logs Redis errors and carries on as if it was a miss, so a
+	// cache outage only makes requests slower.
+	FailOpen ErrorPolicy = iota
+	// FailClosed returns Redis errors to the caller.
+	FailClosed
+)
+
+//	options
+
+type Options[Q any, T any] struct {
+	// Name labels the stats of this cache with its layer, e.g. "service".
+	Name string
+	// Family labels the metrics with the key family, e.g.
+	// "service::GetProducts" (defaults to Name).
+	Family string
+	// Key builds the Redis key for a query; it must be canonical, i.e. two
+	// queries with the same result must produce the same key.
+	Key     func(query Q) string
+	TTL     time.Duration
+	Codec   Codec[T]
+	OnError ErrorPolicy
+	// Lock enables the cross-instance rebuild lock. Leave it zero to only
+	// coalesce callers inside this process.
+	Lock LockOptions
+	// Stale turns on stale-while-revalidate: an entry is fresh for TTL, then
+	// served as is for up to Stale longer while one background refresh runs.
+	Stale time.Duration
+	// EarlyRefresh (XFetch beta, usually 1) starts that refresh before the
+	// entry goes stale, with a probability rising towards expiry. 0 disables.
+	EarlyRefresh float64
+	// Local is an optional L1 in front of redis (nil = redis only).
+	Local *Local
+	// Missing is the loader error for a query without a result (e.g. an
+	// unknown id). With MissingTTL it is cached that long, so repeated
+	// lookups of what does not exist stop reaching the loader.
+	Missing    error
+	MissingTTL time.Duration
+	// Tags name what an entry was built from (see ProductTag): the key is
+	// added to those tag sets when it is stored, and InvalidateTags drops
+	// it. value is the zero T for a missing entry.
+	Tags func(query Q, value T) []string
+}
+
+// LockOptions configure the Redis rebuild lock: on a miss only the caller
+// holding <key>::lock runs the loader, the others poll the key for up to
+// Wait and then take the last value (<key>::last, kept for KeepLast).
+type LockOptions struct {
+	TTL      time.Duration
+	Wait     time.Duration
+	KeepLast time.Duration
+}
+
+func (o LockOptions) enabled() bool {
+	return o.TTL > 0
+}
+
+//	cache-aside
+
+// Aside is a cache-aside wrapper: look in Redis, fall back to load on a miss
+// and store what load returned. Concurrent misses on the same key share one
+// load, which runs under a context of its own (bounded by the lock TTL, or
+// the TTL without a lock): a caller cancelled or out of time stops waiting
+// for it, but neither fails the others nor stops the load. It is safe for
+// concurrent use.
+type Aside[Q any, T any] struct {
+	redisClient redis.UniversalClient
+	options     Options[Q, T]
+	group       *singleflight.Group
+}
+
+func NewAside[Q any, T any](redisClient redis.UniversalClient, options Options[Q, T]) Aside[Q, T] {
+	if options.Name == "" {
+		options.Name = "default"
+	}
+	if options.Family == "" {
+		options.Family = options.Name
+	}
+	if options.TTL <= 0 {
+		options.TTL = time.Second * 10
+	}
+	if options.Codec == nil {
+		options.Codec = NewCodec[T](Format{Codec: CodecJSON})
+	}
+	if options.Lock.enabled() {
+		if options.Lock.Wait <= 0 {
+			options.Lock.Wait = options.Lock.TTL
+		}
+		if options.Lock.KeepLast <= 0 {
+			options.Lock.KeepLast = options.TTL * 6
+		}
+	}
+	// the stored bytes tell whether there is an envelope: another instance
+	// may run with other options
+	registerDecoder(options.Family, func(data []byte) (interface{}, error) {
+		return options.Codec.Unmarshal(stripEnvelope(data))
+	})
+	return Aside[Q, T]{redisClient: redisClient, options: options, group: &singleflight.Group{}}
+}
+
+// Get passes ctx, and its deadline, to Redis and waits for load no longer
+// than ctx allows; the shared load and a background refresh get a context
+// of their own. The lookup is a span (cache.Get) with its layer, family,
+// result and source (l1, redis or load).
+func (a Aside[Q, T]) Get(ctx context.Context, query Q, load func(context.Context, Q) (T, error)) (value T, err error) {
+
+	key := a.options.Key(query)
+	ctx, span := tracing.Start(ctx, "cache.Get",
+		attribute.String("cache.layer", a.options.Name),
+		attribute.String("cache.family", a.options.Family))
+	defer func() { tracing.End(span, err, a.options.Missing) }()
+
+	// 	L1 get
+	if e, ok := a.getLocal(key); ok {
+		annotate(span, metrics.Hit, "l1")
+		return a.result(e)
+	}
+
+	// 	redis get
+	e, ok, err := a.get(ctx, key)
+	if err != nil {
+		return value, err
+	}
+	if ok {
+		count(a.options.Name, statL2Hits)
+		a.setLocal(key, e)
+		if e.missing {
+			count(a.options.Name, statMissing)
+			a.observe(metrics.Hit)
+			annotate(span, metrics.Hit, "redis")
+			return a.result(e)
+		}
+		now := time.Now()
+		switch {
+		case e.stale(now):
+			count(a.options.Name, statStale)
+			a.observe(metrics.Stale)
+			annotate(span, metrics.Stale, "redis")
+			a.refresh(key, query, load, statRefreshes)
+			return e.value, nil
+		case e.early(now, a.options.EarlyRefresh):
+			a.refresh(key, query, load, statEarlyRefreshes)
+		}
+		a.observe(metrics.Hit)
+		annotate(span, metrics.Hit, "redis")
+		return e.value, nil
+	}
+
+	count(a.options.Name, statL2Misses)
+	a.observe(metrics.Miss)
+	annotate(span, metrics.Miss, "load")
+
+	// the deadline ran out while redis timed out (failing open): the loader
+	// would only fail as well
+	if err := ctx.Err(); err != nil {
+		return value, err
+	}
+
+	// 	load (once per key in this process)
+	leader := false
+	results := a.group.DoChan(key, func() (interface{}, error) {
+		leader = true
+		// the span of the first caller, not its cancellation
+		loadCtx, cancel := context.WithTimeout(trace.ContextWithSpan(context.Background(), span), a.loadTimeout())
+		defer cancel()
+		return a.rebuild(loadCtx, key, query, load)
+	})
+	select {
+	case <-ctx.Done():
+		return value, ctx.Err()
+	case result := <-results:
+		// leader is set before the result is sent
+		if result.Shared && !leader {
+			count(a.options.Name, statCoalesced)
+		}
+		if result.Err != nil {
+			return value, result.Err
+		}
+		return result.Val.(T), nil
+	}
+}
+
+// Warm loads query and stores it whether or not it is cached, to fill the
+// cache before the first requests or refresh it before its TTL runs out.
+func (a Aside[Q, T]) Warm(ctx context.Context, query Q, load func(context.Context, Q) (T, error)) error {
+	key := a.options.Key(query)
+	_, err, _ := a.group.Do(key, func() (interface{}, error) {
+		count(a.options.Name, statWarms)
+		return a.load(ctx, key, query, load)
+	})
+	return err
+}
+
+// rebuild runs load under the Redis lock (when enabled) and stores the result.
+func (a Aside[Q, T]) rebuild(ctx context.Context, key string, query Q, load func(context.Context, Q) (T, error)) (value T, err error) {
+
+	if a.options.Lock.enabled() {
+		token, acquired, err := a.lock(ctx, key, a.options.Lock.TTL)
+		if err != nil {
+			if err := a.fail("lock", key, err); err != nil {
+				return value, err
+			}
+		} else if acquired {
+			defer a.unlock(key, token)
+			// the previous holder may have stored it between our get and lock
+			if e, ok, _ := a.get(ctx, key); ok && !e.stale(time.Now()) {
+				count(a.options.Name, statLockWaits)
+				return a.result(e)
+			}
+		} else if e, ok := a.wait(ctx, key); ok {
+			return a.result(e)
+		}
+	}
+
+	return a.load(ctx, key, query, load)
+}
+
+// load calls the loader and stores what it returned.
+func (a Aside[Q, T]) load(ctx context.Context, key string, query Q, load func(context.Context, Q) (T, error)) (value T, err error) {
+
+	count(a.options.Name, statLoads)
+	start := time.Now()
+	value, err = load(ctx, query)
+	if err != nil {
+		if a.negative(err) {
+			if err := a.setMissing(ctx, key, query); err != nil {
+				return value, err
+			}
+		}
+		return value, err
+	}
+
+	// 	redis set
+	if err := a.set(ctx, key, query, value, time.Since(start)); err != nil {
+		return value, err
+	}
+
+	return value, nil
+}
+
+// refresh reloads a stale (or nearly stale) key in the background while the
+// caller is served the cached value. One refresh runs per key in this
+// process, and across instances only the one holding the refresh lock runs.
+func (a Aside[Q, T]) refresh(key string, query Q, load func(context.Context, Q) (T, error), stat string) {
+	a.group.DoChan(Key(key, "refresh"), func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), a.loadTimeout())
+		defer cancel()
+
+		token, acquired, err := a.lock(ctx, Key(key, "refresh"), a.loadTimeout())
+		if err != nil || !acquired {
+			return nil, err
+		}
+		defer a.unlock(Key(key, "refresh"), token)
+
+		count(a.options.Name, stat)
+		if _, err := a.load(ctx, key, query, load); err != nil {
+			log.Printf("cache: refresh %v: %v", key, err)
+		}
+		return nil, nil
+	})
+}
+
+// loadTimeout bounds the loads that run for several callers or none (shared
+// misses, refreshes): a load holding the lock longer would race the next.
+func (a Aside[Q, T]) loadTimeout() time.Duration {
+	if a.options.Lock.enabled() {
+		return a.options.Lock.TTL
+	}
+	return a.options.TTL
+}
+
+func (a Aside[Q, T]) get(ctx context.Context, key string) (e entry[T], ok bool, err error) {
+	data, err := a.redisClient.Get(ctx, key).Bytes()
+	switch {
+	case err == nil && string(data) == missingValue:
+		e.missing = true
+		return e, true, nil
+	case err == nil:
+		e, err = a.decode(data)
+		if err == nil {
+			return e, true, nil
+		}
+		// a payload we cannot read is a miss; the next set replaces it
+		log.Printf("cache: decode %v: %v", key, err)
+		return e, false, nil
+	case errors.Is(err, redis.Nil):
+		return e, false, nil
+	}
+	return e, false, a.fail("get", key, err)
+}
+
+// set stores value, and tags the keys in the same MULTI (see PruneTags).
+func (a Aside[Q, T]) set(ctx context.Context, key string, query Q, value T, delta time.Duration) error {
+	data, err := a.encode(value, delta)
+	if err != nil {
+		return a.fail("encode", key, err)
+	}
+
+	ttl := a.options.TTL + a.options.Stale
+	pipe := a.redisClient.TxPipeline()
+	if a.options.Lock.enabled() {
+		a.tag(ctx, pipe, query, value, maxDuration(ttl, a.options.Lock.KeepLast), key, Key(key, "last"))
+		pipe.Set(ctx, Key(key, "last"), data, a.options.Lock.KeepLast)
+	} else {
+		a.tag(ctx, pipe, query, value, ttl, key)
+	}
+	pipe.Set(ctx, key, data, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return a.fail("set", key, err)
+	}
+	a.setLocal(key, entry[T]{value: value, soft: a.softExpiry(), delta: delta})
+	return nil
+}
+
+// setMissing stores the tombstone of a query without a result. There is no
+// last good copy to keep, and the L1 holds it no longer than redis.
+func (a Aside[Q, T]) setMissing(ctx context.Context, key string, query Q) error {
+	pipe := a.redisClient.TxPipeline()
+	var zero T
+	a.tag(ctx, pipe, query, zero, a.options.MissingTTL, key)
+	pipe.Set(ctx, key, missingValue, a.options.MissingTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return a.fail("set", key, err)
+	}
+	a.setLocal(key, entry[T]{missing: true})
+	return nil
+}
+
+func (a Aside[Q, T]) tag(ctx context.Context, pipe redis.Pipeliner, query Q, value T, ttl time.Duration, keys ...string) {
+	if a.options.Tags == nil {
+		return
+	}
+	tagKeys(ctx, pipe, a.options.Tags(query, value), ttl, keys...)
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// getLocal only serves fresh entries; stale ones go to redis so the
+// stale-while-revalidate refresh is still triggered there.
+func (a Aside[Q, T]) getLocal(key string) (e entry[T], ok bool) {
+	if a.options.Local == nil {
+		return e, false
+	}
+	if cached, ok := a.options.Local.Get(key); ok {
+		if e, ok := cached.(entry[T]); ok && !e.stale(time.Now()) {
+			count(a.options.Name, statL1Hits)
+			a.observe(metrics.Hit)
+			return e, true
+		}
+	}
+	count(a.options.Name, statL1Misses)
+	return e, false
+}
+
+func (a Aside[Q, T]) setLocal(key string, e entry[T]) {
+	if a.options.Local == nil {
+		return
+	}
+	expires := e.soft
+	if e.missing {
+		expires = time.Now().Add(a.options.MissingTTL)
+	}
+	a.options.Local.Set(key, e, expires)
+}
+
+//	encoding
+
+func (a Aside[Q, T]) enveloped() bool {
+	return a.options.Stale > 0 || a.options.EarlyRefresh > 0
+}
+
+func (a Aside[Q, T]) encode(value T, delta time.Duration) ([]byte, error) {
+	data, err := a.options.Codec.Marshal(value)
+	if err != nil || !a.enveloped() {
+		return data, err
+	}
+	return wrap(data, a.softExpiry(), delta), nil
+}
+
+func (a Aside[Q, T]) softExpiry() time.Time {
+	if !a.enveloped() {
+		return time.Time{}
+	}
+	return time.Now().Add(a.options.TTL)
+}
+
+func (a Aside[Q, T]) decode(data []byte) (e entry[T], err error) {
+	if a.enveloped() {
+		if data, e.soft, e.delta, err = unwrap(data); err != nil {
+			return e, err
+		}
+	}
+	e.value, err = a.options.Codec.Unmarshal(data)
+	return e, err
+}
+
+//	negative caching
+
+// missingValue is the tombstone of a query without a result: a frame starts
+// with its version (1) and an envelope with a 0 byte, so neither is ever
+// read as one.
+const missingValue = "missing"
+
+func (a Aside[Q, T]) negative(err error) bool {
+	return a.options.Missing != nil && a.options.MissingTTL > 0 && errors.Is(err, a.options.Missing)
+}
+
+// result is what a cached entry answers: its value, or Missing.
+func (a Aside[Q, T]) result(e entry[T]) (T, error) {
+	if e.missing {
+		return e.value, a.options.Missing
+	}
+	return e.value, nil
+}
+
+func (a Aside[Q, T]) observe(result string) {
+	metrics.CacheRequests.WithLabelValues(a.options.Name, a.options.Family, result).Inc()
+}
+
+// annotate records the result of a lookup on its span.
+func annotate(span trace.Span, result string, source string) {
+	span.SetAttributes(attribute.String("cache.result", result), attribute.String("cache.source", source))
+}
+
+func (a Aside[Q, T]) fail(op string, key string, err error) error {
+	a.observe(metrics.Error)
+	if a.options.OnError == FailClosed {
+		return fmt.Errorf("cache: %v %v: %w", op, key, err)
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		// the breaker already logged the outage, one line per request is noise
+		count(a.options.Name, statBypassed)
+		return nil
+	}
+	log.Printf("cache: %v %v: %v", op, key, err)
+	return nil
+}
+
+//	rebuild lock
+
+// unlockScript deletes the lock only if we still own it, so a rebuild that
+// outlived its lock TTL cannot release somebody else's lock.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (a Aside[Q, T]) lock(ctx context.Context, key string, ttl time.Duration) (token string, acquired bool, err error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", false, err
+	}
+	token = hex.EncodeToString(buf)
+	acquired, err = a.redisClient.SetNX(ctx, Key(key, "lock"), token, ttl).Result()
+	return token, acquired, err
+}
+
+func (a Aside[Q, T]) unlock(key string, token string) {
+	// the request may be gone by now, the lock must still be released
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := unlockScript.Run(ctx, a.redisClient, []string{Key(key, "lock")}, token).Err(); err != nil && !errors.Is(err, redis.Nil) {
+		log.Printf("cache: unlock %v: %v", key, err)
+	}
+}
+
+// wait polls for the value another instance is rebuilding. If it does not
+// show up within Lock.Wait the last known value is used instead; ok is false
+// when there is neither and the caller has to load it itself.
+func (a Aside[Q, T]) wait(ctx context.Context, key string) (e entry[T], ok bool) {
+	ticker := time.NewTicker(25 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.NewTimer(a.options.Lock.Wait)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return e, false
+		case <-timeout.C:
+			if e, ok, _ := a.get(ctx, Key(key, "last")); ok {
+				count(a.options.Name, statLastServed)
+				return e, true
+			}
+			return e, false
+		case <-ticker.C:
+			if e, ok, _ := a.get(ctx, key); ok {
+				count(a.options.Name, statLockWaits)
+				return e, true
+			}
+		}
+	}
+}
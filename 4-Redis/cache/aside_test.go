@@ -0,0 +1,397 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// testLoader counts its calls and answers value, or err.
+type testLoader struct {
+	calls int32
+	value atomic.Value
+	err   error
+}
+
+func newTestLoader(value string, err error) *testLoader {
+	l := &testLoader{err: err}
+	l.value.Store(value)
+	return l
+}
+
+func (l *testLoader) load(ctx context.Context, id int) (string, error) {
+	atomic.AddInt32(&l.calls, 1)
+	if l.err != nil {
+		return "", l.err
+	}
+	return l.value.Load().(string) + ":" + strconv.Itoa(id), nil
+}
+
+func (l *testLoader) loads() int {
+	return int(atomic.LoadInt32(&l.calls))
+}
+
+func testOptions(t *testing.T, options Options[int, string]) Options[int, string] {
+	options.Name = "test"
+	options.Family = Key("test", t.Name())
+	options.Key = func(id int) string {
+		return ProductKey(options.Family, id)
+	}
+	if options.TTL == 0 {
+		options.TTL = time.Minute
+	}
+	return options
+}
+
+func TestAsideGet(t *testing.T) {
+	errNotFound := errors.New("not found")
+	errLoad := errors.New("database down")
+
+	tests := []struct {
+		name      string
+		options   Options[int, string]
+		loadErr   error
+		wantValue string
+		wantErr   error
+		wantLoads int
+	}{
+		{name: "miss then hits", wantValue: "v:1", wantLoads: 1},
+		{name: "hits from the L1", options: Options[int, string]{Local: NewLocal(10, time.Minute)}, wantValue: "v:1", wantLoads: 1},
+		{name: "hits under the lock", options: Options[int, string]{Lock: LockOptions{TTL: time.Second}}, wantValue: "v:1", wantLoads: 1},
+		{name: "hits in an envelope", options: Options[int, string]{Stale: time.Minute, EarlyRefresh: 1}, wantValue: "v:1", wantLoads: 1},
+		{name: "loader errors are not cached", loadErr: errLoad, wantErr: errLoad, wantLoads: 3},
+		{
+			name:      "missing is cached",
+			options:   Options[int, string]{Missing: errNotFound, MissingTTL: time.Minute},
+			loadErr:   errNotFound,
+			wantErr:   errNotFound,
+			wantLoads: 1,
+		},
+		{
+			name:      "missing is cached in the L1",
+			options:   Options[int, string]{Missing: errNotFound, MissingTTL: time.Minute, Local: NewLocal(10, time.Minute)},
+			loadErr:   errNotFound,
+			wantErr:   errNotFound,
+			wantLoads: 1,
+		},
+		{
+			name:      "missing without MissingTTL",
+			options:   Options[int, string]{Missing: errNotFound},
+			loadErr:   errNotFound,
+			wantErr:   errNotFound,
+			wantLoads: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, redisClient := newTestRedis(t)
+			aside := NewAside(redisClient, testOptions(t, tt.options))
+			loader := newTestLoader("v", tt.loadErr)
+
+			for i := 0; i < 3; i++ {
+				value, err := aside.Get(context.Background(), 1, loader.load)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("get %d: err = %v, want %v", i, err, tt.wantErr)
+				}
+				if value != tt.wantValue {
+					t.Fatalf("get %d: value = %q, want %q", i, value, tt.wantValue)
+				}
+			}
+			if loader.loads() != tt.wantLoads {
+				t.Fatalf("loads = %d, want %d", loader.loads(), tt.wantLoads)
+			}
+		})
+	}
+}
+
+func TestAsideGetRedisDown(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  ErrorPolicy
+		wantErr bool
+	}{
+		{name: "fail open loads", policy: FailOpen},
+		{name: "fail closed returns the error", policy: FailClosed, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, redisClient := newTestRedis(t)
+			mr.Close()
+			aside := NewAside(redisClient, testOptions(t, Options[int, string]{OnError: tt.policy}))
+			loader := newTestLoader("v", nil)
+
+			value, err := aside.Get(context.Background(), 1, loader.load)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && value != "v:1" {
+				t.Fatalf("value = %q, want v:1", value)
+			}
+		})
+	}
+}
+
+func TestAsideCoalescesMisses(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	aside := NewAside(redisClient, testOptions(t, Options[int, string]{}))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	loader := newTestLoader("v", nil)
+	load := func(ctx context.Context, id int) (string, error) {
+		once.Do(func() { close(started) })
+		<-release
+		return loader.load(ctx, id)
+	}
+
+	const callers = 20
+	results := make(chan string, callers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := aside.Get(context.Background(), 1, load)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- value
+		}()
+	}
+
+	<-started
+	time.Sleep(50 * time.Millisecond) // let the other callers join the load
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for value := range results {
+		if value != "v:1" {
+			t.Fatalf("value = %q, want v:1", value)
+		}
+	}
+	if loader.loads() != 1 {
+		t.Fatalf("loads = %d, want 1", loader.loads())
+	}
+}
+
+func TestAsideSharedLoadOutlivesCaller(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	aside := NewAside(redisClient, testOptions(t, Options[int, string]{}))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loader := newTestLoader("v", nil)
+	load := func(ctx context.Context, id int) (string, error) {
+		close(started)
+		<-release
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		return loader.load(ctx, id)
+	}
+
+	// the first caller starts the load and gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := aside.Get(ctx, 1, load)
+		first <- err
+	}()
+	<-started
+
+	second := make(chan string)
+	go func() {
+		value, err := aside.Get(context.Background(), 1, load)
+		if err != nil {
+			t.Error(err)
+		}
+		second <- value
+	}()
+	time.Sleep(20 * time.Millisecond) // let the second caller join the load
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller: err = %v, want context.Canceled", err)
+	}
+	close(release)
+	if value := <-second; value != "v:1" {
+		t.Fatalf("other caller: value = %q, want v:1", value)
+	}
+	if loader.loads() != 1 {
+		t.Fatalf("loads = %d, want 1", loader.loads())
+	}
+	// and the result was stored
+	if value, err := aside.Get(context.Background(), 1, loader.load); err != nil || value != "v:1" || loader.loads() != 1 {
+		t.Fatalf("get after the load = %q, %v with %d loads", value, err, loader.loads())
+	}
+}
+
+func TestAsideRebuildLock(t *testing.T) {
+	lock := LockOptions{TTL: time.Second, Wait: 200 * time.Millisecond, KeepLast: time.Minute}
+
+	tests := []struct {
+		name string
+		// other is the instance holding the lock
+		other     func(t *testing.T, other Aside[int, string], redisClient redis.UniversalClient)
+		wantValue string
+		wantLoads int
+	}{
+		{
+			name: "waits for the holder",
+			other: func(t *testing.T, other Aside[int, string], _ redis.UniversalClient) {
+				time.AfterFunc(50*time.Millisecond, func() {
+					other.Warm(context.Background(), 1, newTestLoader("other", nil).load)
+				})
+			},
+			wantValue: "other:1",
+		},
+		{
+			name: "serves the last copy",
+			other: func(t *testing.T, other Aside[int, string], redisClient redis.UniversalClient) {
+				if err := other.Warm(context.Background(), 1, newTestLoader("last", nil).load); err != nil {
+					t.Fatal(err)
+				}
+				redisClient.Del(context.Background(), other.options.Key(1))
+			},
+			wantValue: "last:1",
+		},
+		{
+			name:      "loads when nothing shows up",
+			other:     func(*testing.T, Aside[int, string], redis.UniversalClient) {},
+			wantValue: "v:1",
+			wantLoads: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, redisClient := newTestRedis(t)
+			options := testOptions(t, Options[int, string]{Lock: lock})
+			aside := NewAside(redisClient, options)
+			other := NewAside(redisClient, options)
+
+			redisClient.Set(context.Background(), Key(options.Key(1), "lock"), "other", time.Second)
+			tt.other(t, other, redisClient)
+
+			loader := newTestLoader("v", nil)
+			value, err := aside.Get(context.Background(), 1, loader.load)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != tt.wantValue {
+				t.Fatalf("value = %q, want %q", value, tt.wantValue)
+			}
+			if loader.loads() != tt.wantLoads {
+				t.Fatalf("loads = %d, want %d", loader.loads(), tt.wantLoads)
+			}
+		})
+	}
+}
+
+func TestAsideStaleWhileRevalidate(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	aside := NewAside(redisClient, testOptions(t, Options[int, string]{TTL: 50 * time.Millisecond, Stale: time.Minute}))
+	loader := newTestLoader("v1", nil)
+
+	if value, err := aside.Get(context.Background(), 1, loader.load); err != nil || value != "v1:1" {
+		t.Fatalf("first get = %q, %v", value, err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	loader.value.Store("v2")
+	// stale: served as is while the refresh runs
+	if value, err := aside.Get(context.Background(), 1, loader.load); err != nil || value != "v1:1" {
+		t.Fatalf("stale get = %q, %v", value, err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		value, err := aside.Get(context.Background(), 1, loader.load)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value == "v2:1" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("value = %q after 1s, the refresh did not store v2:1", value)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if loader.loads() != 2 {
+		t.Fatalf("loads = %d, want 2", loader.loads())
+	}
+}
+
+func TestEntryFreshness(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		entry     entry[string]
+		beta      float64
+		wantStale bool
+		wantEarly bool
+	}{
+		{name: "no envelope", entry: entry[string]{delta: time.Second}, beta: 1},
+		{name: "fresh, fast load", entry: entry[string]{soft: now.Add(time.Hour), delta: time.Millisecond}, beta: 1},
+		{name: "early refresh off", entry: entry[string]{soft: now.Add(time.Nanosecond), delta: time.Hour}, beta: 0},
+		{name: "past the soft expiry", entry: entry[string]{soft: now.Add(-time.Second), delta: time.Millisecond}, beta: 1, wantStale: true, wantEarly: true},
+		{name: "at the soft expiry", entry: entry[string]{soft: now, delta: time.Millisecond}, beta: 1, wantStale: true, wantEarly: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if stale := tt.entry.stale(now); stale != tt.wantStale {
+				t.Fatalf("stale = %v, want %v", stale, tt.wantStale)
+			}
+			// early is random, but certain at both ends
+			for i := 0; i < 100; i++ {
+				if early := tt.entry.early(now, tt.beta); early != tt.wantEarly {
+					t.Fatalf("early = %v, want %v", early, tt.wantEarly)
+				}
+			}
+		})
+	}
+}
+
+func TestEntryEarlyRefreshRate(t *testing.T) {
+	// with beta = 1 the chance of refreshing is 1 - exp(-remaining/delta)
+	now := time.Now()
+	e := entry[string]{soft: now.Add(time.Second), delta: time.Second}
+
+	early := 0
+	for i := 0; i < 10000; i++ {
+		if e.early(now, 1) {
+			early++
+		}
+	}
+	// exp(-1) = 0.37
+	if early < 3300 || early > 4100 {
+		t.Fatalf("refreshed early %d times out of 10000, want about 3700", early)
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	soft := time.UnixMilli(time.Now().UnixMilli())
+	data, gotSoft, delta, err := unwrap(wrap([]byte("payload"), soft, 1500*time.Microsecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "payload" || !gotSoft.Equal(soft) || delta != 1500*time.Microsecond {
+		t.Fatalf("unwrap = %q, %v, %v", data, gotSoft, delta)
+	}
+	if _, _, _, err := unwrap([]byte("short")); !errors.Is(err, errShortEnvelope) {
+		t.Fatalf("unwrap short: err = %v", err)
+	}
+}
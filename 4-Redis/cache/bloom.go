@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+
+	"github.com/go-redis/redis/v9"
+)
+
+//	bloom filter (redis bitmap)
+
+// Bloom is a Bloom filter in a Redis bitmap shared by every instance: it
+// never says no to an item that was added, and says yes to one that was not
+// with about the false positive rate it was sized for. Items cannot be
+// removed; a filter is rebuilt from the source of truth instead.
+type Bloom struct {
+	redisClient redis.UniversalClient
+	key         string
+	bits        uint64
+	hashes      int
+}
+
+// NewBloom sizes the filter name for capacity items at false positive rate
+// p. The sizes are part of the key, so instances configured differently
+// never read each other's bits.
+func NewBloom(redisClient redis.UniversalClient, name string, capacity int, p float64) Bloom {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	bits := math.Ceil(-float64(capacity) * math.Log(p) / (math.Ln2 * math.Ln2))
+	hashes := math.Max(1, math.Round(bits/float64(capacity)*math.Ln2))
+	b := Bloom{redisClient: redisClient, bits: uint64(bits), hashes: int(hashes)}
+	// hash tag: the rebuild keys must share the slot for BITOP, RENAME and the scripts
+	b.key = Key(Tag(name), fmt.Sprintf("m=%d&k=%d", b.bits, b.hashes))
+	return b
+}
+
+func (b Bloom) Key() string {
+	return b.key
+}
+
+func (b Bloom) building() string {
+	return Key(b.key, "rebuild")
+}
+
+// offsets are the bits of item, by double hashing (Kirsch-Mitzenmacher) of
+// one 64-bit FNV-1a.
+func (b Bloom) offsets(item string) []interface{} {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+
+	offsets := make([]interface{}, b.hashes)
+	for i := range offsets {
+		offsets[i] = (h1 + uint64(i)*h2) % b.bits
+	}
+	return offsets
+}
+
+// bloomAddScript sets the bits in the filter and, during a rebuild, in the
+// new one too, so an item added meanwhile is not lost by the RENAME. A
+// filter that does not exist is left alone: a partial one would say no to
+// everything that was never added.
+var bloomAddScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	if redis.call("EXISTS", key) == 1 then
+		for i = 1, #ARGV do
+			redis.call("SETBIT", key, ARGV[i], 1)
+		end
+	end
+end
+return 1
+`)
+
+// bloomTestScript returns 1 when every bit is set (or there is no filter
+// yet, which cannot tell), 0 when the item was certainly never added.
+var bloomTestScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 1
+end
+for i = 1, #ARGV do
+	if redis.call("GETBIT", KEYS[1], ARGV[i]) == 0 then
+		return 0
+	end
+end
+return 1
+`)
+
+// Add records item in the filter, if it has been built.
+func (b Bloom) Add(ctx context.Context, item string) error {
+	return bloomAddScript.Run(ctx, b.redisClient, []string{b.key, b.building()}, b.offsets(item)...).Err()
+}
+
+// MayContain is false only for an item that was never added. Without a
+// filter it is always true.
+func (b Bloom) MayContain(ctx context.Context, item string) (bool, error) {
+	maybe, err := bloomTestScript.Run(ctx, b.redisClient, []string{b.key}, b.offsets(item)...).Int()
+	return maybe == 1, err
+}
+
+// Drop deletes the filter, so MayContain lets every item through until the
+// next Rebuild: an item whose Add failed is not rejected meanwhile.
+func (b Bloom) Drop(ctx context.Context) error {
+	return b.redisClient.Del(ctx, b.key).Err()
+}
+
+func (b Bloom) Exists(ctx context.Context) (bool, error) {
+	n, err := b.redisClient.Exists(ctx, b.key).Result()
+	return n > 0, err
+}
+
+// Rebuild fills a new filter with the items each passes to add, then swaps
+// it in. The bits are set in memory and written with one SET (one SETBIT
+// per bit would take seconds for a million items); meanwhile Add writes to
+// the rebuild key, which is OR-ed with them, so no new item is lost.
+func (b Bloom) Rebuild(ctx context.Context, each func(add func(items ...string) error) error) error {
+	building, bits := b.building(), Key(b.key, "bits")
+	pipe := b.redisClient.Pipeline()
+	pipe.Del(ctx, building)
+	pipe.SetBit(ctx, building, 0, 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	bitmap := make([]byte, (b.bits+7)/8)
+	err := each(func(items ...string) error {
+		for _, item := range items {
+			for _, offset := range b.offsets(item) {
+				//	redis numbers the bits of a byte from the most significant
+				bitmap[offset.(uint64)/8] |= 0x80 >> (offset.(uint64) % 8)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.redisClient.Del(ctx, building)
+		return err
+	}
+
+	pipe = b.redisClient.Pipeline()
+	pipe.Set(ctx, bits, bitmap, 0)
+	pipe.BitOpOr(ctx, building, building, bits)
+	pipe.Del(ctx, bits)
+	pipe.Rename(ctx, building, b.key)
+	_, err = pipe.Exec(ctx)
+	return err
+}
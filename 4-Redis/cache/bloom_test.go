@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, redis.UniversalClient) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+	return mr, redisClient
+}
+
+func TestBloomSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		capacity   int
+		p          float64
+		wantBits   uint64
+		wantHashes int
+	}{
+		{name: "1% of 1000", capacity: 1000, p: 0.01, wantBits: 9586, wantHashes: 7},
+		{name: "0.1% of 1000", capacity: 1000, p: 0.001, wantBits: 14378, wantHashes: 10},
+		{name: "rate out of range", capacity: 1000, p: 2, wantBits: 9586, wantHashes: 7},
+		{name: "no capacity", capacity: 0, p: 0.01, wantBits: 10, wantHashes: 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBloom(nil, "test", tt.capacity, tt.p)
+			if b.bits != tt.wantBits || b.hashes != tt.wantHashes {
+				t.Fatalf("bits, hashes = %d, %d, want %d, %d", b.bits, b.hashes, tt.wantBits, tt.wantHashes)
+			}
+			if want := "{test}::m=" + strconv.FormatUint(tt.wantBits, 10) + "&k=" + strconv.Itoa(tt.wantHashes); b.Key() != want {
+				t.Fatalf("key = %q, want %q", b.Key(), want)
+			}
+		})
+	}
+}
+
+func TestBloom(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	ctx := context.Background()
+	const capacity = 1000
+	b := NewBloom(redisClient, "test", capacity, 0.01)
+
+	// without a filter nothing can be ruled out, and Add does not start one
+	if err := b.Add(ctx, "0"); err != nil {
+		t.Fatal(err)
+	}
+	if maybe, err := b.MayContain(ctx, "anything"); err != nil || !maybe {
+		t.Fatalf("no filter: MayContain = %v, %v, want true", maybe, err)
+	}
+	if exists, _ := b.Exists(ctx); exists {
+		t.Fatal("Add created the filter")
+	}
+
+	err := b.Rebuild(ctx, func(add func(items ...string) error) error {
+		for i := 1; i <= capacity; i++ {
+			if err := add(strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(ctx, "added-later"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range []string{"1", "500", "1000", "added-later"} {
+		if maybe, err := b.MayContain(ctx, item); err != nil || !maybe {
+			t.Fatalf("MayContain(%v) = %v, %v, want true", item, maybe, err)
+		}
+	}
+
+	falsePositives := 0
+	for i := capacity + 1; i <= capacity+2000; i++ {
+		maybe, err := b.MayContain(ctx, strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if maybe {
+			falsePositives++
+		}
+	}
+	// sized for 1%
+	if falsePositives > 60 {
+		t.Fatalf("%d false positives out of 2000, want about 20", falsePositives)
+	}
+}
+
+func TestBloomRebuildReplaces(t *testing.T) {
+	mr, redisClient := newTestRedis(t)
+	ctx := context.Background()
+	b := NewBloom(redisClient, "test", 100, 0.01)
+
+	rebuild := func(items ...string) {
+		t.Helper()
+		err := b.Rebuild(ctx, func(add func(items ...string) error) error {
+			return add(items...)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rebuild("old")
+	rebuild("new")
+	if maybe, _ := b.MayContain(ctx, "new"); !maybe {
+		t.Fatal("new item missing after the rebuild")
+	}
+	if maybe, _ := b.MayContain(ctx, "old"); maybe {
+		t.Fatal("old item still in the filter after the rebuild")
+	}
+	if mr.Exists(b.building()) {
+		t.Fatal("rebuild key left behind")
+	}
+}
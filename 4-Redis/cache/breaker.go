@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+//	circuit breaker (redis hook)
+
+// ErrCircuitOpen is returned for every redis command while the breaker is
+// open. Aside treats it like any other redis error, so with FailOpen the
+// request goes straight to the loader without waiting for a timeout.
+var ErrCircuitOpen = errors.New("cache: redis circuit open")
+
+type BreakerState int
+
+const (
+	Closed BreakerState = iota
+	Open
+	HalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+type BreakerOptions struct {
+	// Failures is how many consecutive failed commands open the circuit.
+	Failures int
+	// OpenTimeout is how long the circuit stays open before probing.
+	OpenTimeout time.Duration
+	// Probes is how many commands may run at once while half-open; one
+	// success closes the circuit, one failure opens it again.
+	Probes int
+	// OnRecover runs (in its own goroutine) when the circuit closes again,
+	// e.g. to drop entries whose invalidation was lost during the outage.
+	OnRecover func()
+}
+
+// Breaker is a redis.Hook; add it with redisClient.AddHook. Only transport
+// failures count: redis.Nil and error replies mean redis is up.
+type Breaker struct {
+	mu       sync.Mutex
+	options  BreakerOptions
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probes   int
+}
+
+var breakerStats = expvar.NewMap("redis_breaker")
+
+func NewBreaker(options BreakerOptions) *Breaker {
+	if options.Failures <= 0 {
+		options.Failures = 5
+	}
+	if options.OpenTimeout <= 0 {
+		options.OpenTimeout = time.Second * 5
+	}
+	if options.Probes <= 0 {
+		options.Probes = 1
+	}
+	state := new(expvar.String)
+	state.Set(Closed.String())
+	breakerStats.Set("state", state)
+	return &Breaker{options: options}
+}
+
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, b.allow()
+}
+
+func (b *Breaker) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	b.done(ctx, cmd.Err())
+	return nil
+}
+
+func (b *Breaker) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, b.allow()
+}
+
+func (b *Breaker) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if failure(cmd.Err()) {
+			err = cmd.Err()
+			break
+		}
+	}
+	b.done(ctx, err)
+	return nil
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && time.Since(b.openedAt) >= b.options.OpenTimeout {
+		b.transition(HalfOpen)
+	}
+
+	switch b.state {
+	case Open:
+		breakerStats.Add("rejected", 1)
+		return ErrCircuitOpen
+	case HalfOpen:
+		if b.probes >= b.options.Probes {
+			breakerStats.Add("rejected", 1)
+			return ErrCircuitOpen
+		}
+		b.probes++
+		breakerStats.Add("probes", 1)
+	}
+	return nil
+}
+
+func (b *Breaker) done(ctx context.Context, err error) {
+	if errors.Is(err, ErrCircuitOpen) {
+		// rejected by allow, nothing was sent
+		return
+	}
+	cancelled := err != nil && ctx.Err() != nil
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == HalfOpen {
+		// release the probe whatever the outcome, or the circuit stays
+		// half-open and rejects every command; a probe that never got its
+		// answer proves nothing, so it opens the circuit again
+		b.probes--
+		if failure(err) || cancelled {
+			b.trip()
+		} else {
+			b.failures = 0
+			b.transition(Closed)
+		}
+		return
+	}
+
+	if cancelled {
+		// the caller's deadline ran out, not necessarily redis
+		return
+	}
+	if !failure(err) {
+		b.failures = 0
+		return
+	}
+	breakerStats.Add("failures", 1)
+	b.failures++
+	if b.state == Closed && b.failures >= b.options.Failures {
+		b.trip()
+	}
+}
+
+func (b *Breaker) trip() {
+	b.openedAt = time.Now()
+	b.probes = 0
+	b.transition(Open)
+}
+
+func (b *Breaker) transition(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	log.Printf("cache: redis circuit %v -> %v", b.state, state)
+	if state == Open {
+		breakerStats.Add("opened", 1)
+	}
+	if b.state == HalfOpen && state == Closed && b.options.OnRecover != nil {
+		go b.options.OnRecover()
+	}
+	b.state = state
+	if s, ok := breakerStats.Get("state").(*expvar.String); ok {
+		s.Set(state.String())
+	}
+}
+
+// failure tells transport problems (timeouts, refused connections) apart
+// from answers: a miss or an error reply both prove redis is reachable.
+func failure(err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var redisErr redis.Error
+	return !errors.As(err, &redisErr)
+}
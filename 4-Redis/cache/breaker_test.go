@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// replyError is an error reply: redis answered.
+type replyError string
+
+func (e replyError) Error() string { return string(e) }
+func (replyError) RedisError()     {}
+
+var errTransport = errors.New("dial tcp 127.0.0.1:6379: connect: connection refused")
+
+// run sends one command through the breaker, failing with err, and returns
+// the error of BeforeProcess (ErrCircuitOpen when rejected).
+func run(b *Breaker, err error) error {
+	ctx := context.Background()
+	cmd := redis.NewStatusCmd(ctx, "get", "key")
+	if _, rejected := b.BeforeProcess(ctx, cmd); rejected != nil {
+		cmd.SetErr(rejected)
+		b.AfterProcess(ctx, cmd)
+		return rejected
+	}
+	cmd.SetErr(err)
+	b.AfterProcess(ctx, cmd)
+	return nil
+}
+
+func TestBreaker(t *testing.T) {
+	const openTimeout = 20 * time.Millisecond
+	wait := errors.New("wait for the open timeout")
+
+	tests := []struct {
+		name string
+		// steps are the errors of the commands sent in turn; wait sleeps
+		// past the open timeout instead
+		steps         []error
+		wantState     BreakerState
+		wantRejected  int
+		wantRecovered bool
+	}{
+		{name: "successes stay closed", steps: []error{nil, nil, nil}, wantState: Closed},
+		{name: "answers are not failures", steps: []error{redis.Nil, replyError("ERR wrong type"), redis.Nil, replyError("NOSCRIPT"), context.Canceled}, wantState: Closed},
+		{name: "a success resets the count", steps: []error{errTransport, errTransport, nil, errTransport, errTransport}, wantState: Closed},
+		{name: "consecutive failures open", steps: []error{errTransport, errTransport, errTransport}, wantState: Open},
+		{name: "open rejects", steps: []error{errTransport, errTransport, errTransport, nil, nil}, wantState: Open, wantRejected: 2},
+		{name: "open until the timeout", steps: []error{errTransport, errTransport, errTransport, wait}, wantState: Open},
+		{
+			name:          "a probe success closes",
+			steps:         []error{errTransport, errTransport, errTransport, wait, nil},
+			wantState:     Closed,
+			wantRecovered: true,
+		},
+		{
+			name:      "a probe failure opens again",
+			steps:     []error{errTransport, errTransport, errTransport, wait, errTransport, nil},
+			wantState: Open, wantRejected: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recovered := make(chan struct{}, 1)
+			b := NewBreaker(BreakerOptions{
+				Failures:    3,
+				OpenTimeout: openTimeout,
+				OnRecover:   func() { recovered <- struct{}{} },
+			})
+
+			rejected := 0
+			for _, err := range tt.steps {
+				if err == wait {
+					time.Sleep(openTimeout + 5*time.Millisecond)
+					continue
+				}
+				if errors.Is(run(b, err), ErrCircuitOpen) {
+					rejected++
+				}
+			}
+
+			if b.State() != tt.wantState {
+				t.Fatalf("state = %v, want %v", b.State(), tt.wantState)
+			}
+			if rejected != tt.wantRejected {
+				t.Fatalf("rejected = %d, want %d", rejected, tt.wantRejected)
+			}
+			select {
+			case <-recovered:
+				if !tt.wantRecovered {
+					t.Fatal("OnRecover ran, want not")
+				}
+			case <-time.After(50 * time.Millisecond):
+				if tt.wantRecovered {
+					t.Fatal("OnRecover did not run")
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerHalfOpenProbes(t *testing.T) {
+	b := NewBreaker(BreakerOptions{Failures: 1, OpenTimeout: time.Millisecond, Probes: 2})
+	run(b, errTransport)
+	time.Sleep(5 * time.Millisecond)
+
+	// two probes in flight, the third command is rejected
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := b.BeforeProcess(ctx, redis.NewStatusCmd(ctx, "get")); err != nil {
+			t.Fatalf("probe %d: %v", i, err)
+		}
+	}
+	if _, err := b.BeforeProcess(ctx, redis.NewStatusCmd(ctx, "get")); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("third command: err = %v, want ErrCircuitOpen", err)
+	}
+	if b.State() != HalfOpen {
+		t.Fatalf("state = %v, want half-open", b.State())
+	}
+}
+
+func TestBreakerCancelledProbe(t *testing.T) {
+	b := NewBreaker(BreakerOptions{Failures: 1, OpenTimeout: time.Millisecond})
+	run(b, errTransport)
+	time.Sleep(5 * time.Millisecond)
+
+	// the probe's caller gives up before redis answers
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := redis.NewStatusCmd(ctx, "get", "key")
+	if _, err := b.BeforeProcess(ctx, cmd); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	cancel()
+	cmd.SetErr(context.Canceled)
+	b.AfterProcess(ctx, cmd)
+	if b.State() != Open {
+		t.Fatalf("state = %v, want open", b.State())
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if err := run(b, nil); err != nil {
+		t.Fatalf("healthy probe: %v", err)
+	}
+	if b.State() != Closed {
+		t.Fatalf("state = %v, want closed", b.State())
+	}
+}
+
+func TestBreakerPipeline(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error
+		wantState BreakerState
+	}{
+		{name: "misses", errs: []error{nil, redis.Nil, redis.Nil}, wantState: Closed},
+		{name: "one transport failure", errs: []error{nil, errTransport, nil}, wantState: Open},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBreaker(BreakerOptions{Failures: 1})
+			ctx := context.Background()
+			cmds := []redis.Cmder{}
+			for _, err := range tt.errs {
+				cmd := redis.NewStatusCmd(ctx, "get")
+				cmd.SetErr(err)
+				cmds = append(cmds, cmd)
+			}
+			b.BeforeProcessPipeline(ctx, cmds)
+			b.AfterProcessPipeline(ctx, cmds)
+			if b.State() != tt.wantState {
+				t.Fatalf("state = %v, want %v", b.State(), tt.wantState)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+//	codec
+
+// Codec turns a cached value into the bytes stored in Redis and back.
+type Codec[T any] interface {
+	Marshal(value T) ([]byte, error)
+	Unmarshal(data []byte) (T, error)
+}
+
+// CodecID names a codec inside the frame header (see NewCodec).
+type CodecID byte
+
+const (
+	CodecJSON     CodecID = 1
+	CodecMsgPack  CodecID = 2
+	CodecGob      CodecID = 3
+	CodecProtobuf CodecID = 4
+	// CodecCustom marks an adapter specific codec passed to Frame; only that
+	// adapter can read it back.
+	CodecCustom CodecID = 0x7f
+)
+
+var codecNames = map[string]CodecID{
+	"json":     CodecJSON,
+	"msgpack":  CodecMsgPack,
+	"gob":      CodecGob,
+	"protobuf": CodecProtobuf,
+}
+
+func (id CodecID) String() string {
+	for name, codecID := range codecNames {
+		if codecID == id {
+			return name
+		}
+	}
+	if id == CodecCustom {
+		return "custom"
+	}
+	return fmt.Sprintf("codec(%d)", byte(id))
+}
+
+func ParseCodec(name string) (CodecID, error) {
+	if id, ok := codecNames[name]; ok {
+		return id, nil
+	}
+	return 0, fmt.Errorf("cache: unknown codec %q (json|msgpack|gob|protobuf)", name)
+}
+
+// standardCodec returns the codec for id, so a frame written with another
+// codec (e.g. before a config change) can still be read.
+func standardCodec[T any](id CodecID) (Codec[T], bool) {
+	switch id {
+	case CodecJSON:
+		return jsonCodec[T]{}, true
+	case CodecMsgPack:
+		return msgpackCodec[T]{}, true
+	case CodecGob:
+		return gobCodec[T]{}, true
+	case CodecProtobuf:
+		return protobufCodec[T]{}, true
+	}
+	return nil, false
+}
+
+//	json
+
+type jsonCodec[T any] struct{}
+
+func JSON[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+func (jsonCodec[T]) Marshal(value T) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec[T]) Unmarshal(data []byte) (value T, err error) {
+	err = json.Unmarshal(data, &value)
+	return value, err
+}
+
+//	messagepack
+
+type msgpackCodec[T any] struct{}
+
+func MsgPack[T any]() Codec[T] {
+	return msgpackCodec[T]{}
+}
+
+func (msgpackCodec[T]) Marshal(value T) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (msgpackCodec[T]) Unmarshal(data []byte) (value T, err error) {
+	err = msgpack.Unmarshal(data, &value)
+	return value, err
+}
+
+//	gob
+
+type gobCodec[T any] struct{}
+
+func Gob[T any]() Codec[T] {
+	return gobCodec[T]{}
+}
+
+func (gobCodec[T]) Marshal(value T) ([]byte, error) {
+	buf := bytes.Buffer{}
+	err := gob.NewEncoder(&buf).Encode(value)
+	return buf.Bytes(), err
+}
+
+func (gobCodec[T]) Unmarshal(data []byte) (value T, err error) {
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
+
+//	protobuf
+
+// ProtoMessage is implemented (on the pointer) by values that can be cached
+// with the protobuf codec. The wire schema lives in proto/catalog.proto; the
+// encoding is written with protowire, so there is no generated code.
+type ProtoMessage interface {
+	MarshalProto() ([]byte, error)
+	UnmarshalProto(data []byte) error
+}
+
+var ErrNotProto = errors.New("cache: value does not implement ProtoMessage")
+
+type protobufCodec[T any] struct{}
+
+func Protobuf[T any]() Codec[T] {
+	return protobufCodec[T]{}
+}
+
+func (protobufCodec[T]) Marshal(value T) ([]byte, error) {
+	message, ok := any(&value).(ProtoMessage)
+	if !ok {
+		return nil, ErrNotProto
+	}
+	return message.MarshalProto()
+}
+
+func (protobufCodec[T]) Unmarshal(data []byte) (value T, err error) {
+	message, ok := any(&value).(ProtoMessage)
+	if !ok {
+		return value, ErrNotProto
+	}
+	err = message.UnmarshalProto(data)
+	return value, err
+}
@@ -0,0 +1,186 @@
+package cache_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"goredis/cache"
+	"goredis/repositories"
+	"goredis/services"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+//	Codec benchmarks on a page of the 5000 products of mockData
+//	-> go test ./cache -run '^$' -bench Codec -benchmem
+//	-> benchstat old.txt new.txt
+
+var (
+	codecs       = []cache.CodecID{cache.CodecJSON, cache.CodecMsgPack, cache.CodecGob, cache.CodecProtobuf}
+	compressions = []cache.Compression{cache.CompressNone, cache.CompressSnappy, cache.CompressZstd}
+)
+
+// dataset is what the service caches for the first size products of the
+// mock data, with the ids and times the database gives them.
+func dataset(size int) services.ProductPage {
+	categories := []repositories.Category{}
+	for i, name := range repositories.MockCategories {
+		categories = append(categories, repositories.Category{ID: i + 1, Name: name})
+	}
+	created := time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	page := services.ProductPage{Paging: services.Paging{Limit: size, Total: int64(size)}}
+	for i, p := range repositories.MockProducts(rand.New(rand.NewSource(1)), categories, size) {
+		category := categories[*p.CategoryID-1]
+		page.Products = append(page.Products, services.Product{
+			ID:        i + 1,
+			Name:      p.Name,
+			Quantity:  p.Quantity,
+			Price:     p.Price,
+			SKU:       p.SKU,
+			Category:  &services.Category{ID: category.ID, Name: category.Name},
+			CreatedAt: created,
+			UpdatedAt: created.Add(time.Duration(i) * time.Second),
+		})
+	}
+	return page
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	pages := map[string]services.ProductPage{
+		"empty":   {Products: []services.Product{}},
+		"one":     dataset(1),
+		"dataset": dataset(repositories.MockSize),
+	}
+
+	for _, codecID := range codecs {
+		for _, compression := range compressions {
+			for name, page := range pages {
+				t.Run(fmt.Sprintf("%v/%v/%v", codecID, compression, name), func(t *testing.T) {
+					codec := cache.NewCodec[services.ProductPage](cache.Format{Codec: codecID, Compression: compression})
+					data, err := codec.Marshal(page)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					info, _, err := cache.ReadFrame(data)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if info.Codec != codecID || info.Compression != compression {
+						t.Fatalf("frame = %v/%v, want %v/%v", info.Codec, info.Compression, codecID, compression)
+					}
+
+					decoded, err := codec.Unmarshal(data)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if decoded.Products == nil {
+						// gob does not tell an empty slice from nil
+						decoded.Products = []services.Product{}
+					}
+					// compared as JSON: the times differ in representation only
+					if got, want := jsonString(t, decoded), jsonString(t, page); got != want {
+						t.Fatalf("round trip changed the page:\n got %.200s\nwant %.200s", got, want)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestCodecReadsOtherFormats(t *testing.T) {
+	page := dataset(10)
+	reader := cache.NewCodec[services.ProductPage](cache.Format{Codec: cache.CodecJSON})
+
+	// an instance configured with another codec reads what the others wrote
+	for _, codecID := range codecs {
+		for _, compression := range compressions {
+			data, err := cache.NewCodec[services.ProductPage](cache.Format{Codec: codecID, Compression: compression}).Marshal(page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := reader.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("%v/%v: %v", codecID, compression, err)
+			}
+			if len(decoded.Products) != len(page.Products) {
+				t.Fatalf("%v/%v: %d products, want %d", codecID, compression, len(decoded.Products), len(page.Products))
+			}
+		}
+	}
+
+	if _, err := reader.Unmarshal([]byte(`{"products":[]}`)); err != cache.ErrFrameVersion {
+		t.Fatalf("unframed payload: err = %v, want ErrFrameVersion", err)
+	}
+}
+
+func TestCodecThreshold(t *testing.T) {
+	codec := cache.NewCodec[services.ProductPage](cache.Format{Codec: cache.CodecJSON, Compression: cache.CompressZstd, Threshold: 1024})
+
+	tests := []struct {
+		name string
+		page services.ProductPage
+		want cache.Compression
+	}{
+		{name: "small", page: dataset(1), want: cache.CompressNone},
+		{name: "large", page: dataset(100), want: cache.CompressZstd},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := codec.Marshal(tt.page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, _, err := cache.ReadFrame(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Compression != tt.want {
+				t.Fatalf("compression = %v, want %v", info.Compression, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkCodec(b *testing.B) {
+	page := dataset(repositories.MockSize)
+
+	for _, codecID := range codecs {
+		for _, compression := range compressions {
+			codec := cache.NewCodec[services.ProductPage](cache.Format{Codec: codecID, Compression: compression})
+			data, err := codec.Marshal(page)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.Run(fmt.Sprintf("%v/%v/marshal", codecID, compression), func(b *testing.B) {
+				b.ReportAllocs()
+				b.ReportMetric(float64(len(data)), "bytes")
+				for i := 0; i < b.N; i++ {
+					if _, err := codec.Marshal(page); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run(fmt.Sprintf("%v/%v/unmarshal", codecID, compression), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := codec.Unmarshal(data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func jsonString(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+//	envelope (soft expiry)
+
+// With stale-while-revalidate or early refresh on, the payload is stored as
+//
+//	soft expiry (unix ms, 8 bytes) | load duration (µs, 8 bytes) | payload
+//
+// and the Redis TTL is the hard expiry (TTL + Stale).
+const envelopeHeader = 16
+
+var errShortEnvelope = errors.New("cache: envelope too short")
+
+type entry[T any] struct {
+	value T
+	soft  time.Time     // zero without an envelope: fresh until Redis drops it
+	delta time.Duration // how long the load took, scales early refresh
+	// missing is the tombstone of a query without a result
+	missing bool
+}
+
+func (e entry[T]) stale(now time.Time) bool {
+	return !e.soft.IsZero() && !now.Before(e.soft)
+}
+
+// early is the XFetch test: the closer to the soft expiry and the slower the
+// load, the more likely one caller starts refreshing ahead of time. With
+// beta = 1 a hot key is almost always refreshed before it goes stale.
+func (e entry[T]) early(now time.Time, beta float64) bool {
+	if beta <= 0 || e.soft.IsZero() {
+		return false
+	}
+	gap := time.Duration(float64(e.delta) * beta * -math.Log(1-rand.Float64()))
+	return !now.Add(gap).Before(e.soft)
+}
+
+func wrap(data []byte, soft time.Time, delta time.Duration) []byte {
+	buf := make([]byte, envelopeHeader+len(data))
+	binary.BigEndian.PutUint64(buf[0:8], uint64(soft.UnixMilli()))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(delta.Microseconds()))
+	copy(buf[envelopeHeader:], data)
+	return buf
+}
+
+func unwrap(buf []byte) (data []byte, soft time.Time, delta time.Duration, err error) {
+	if len(buf) < envelopeHeader {
+		return nil, soft, delta, errShortEnvelope
+	}
+	soft = time.UnixMilli(int64(binary.BigEndian.Uint64(buf[0:8])))
+	delta = time.Duration(binary.BigEndian.Uint64(buf[8:16])) * time.Microsecond
+	return buf[envelopeHeader:], soft, delta, nil
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+//	frame : version | codec | compression | payload
+
+// FrameVersion is the first byte of every framed payload. Bump it when the
+// header changes; entries with another version are read as misses and
+// rewritten, so Redis never needs a flush.
+const FrameVersion byte = 1
+
+const frameHeader = 3
+
+type Compression byte
+
+const (
+	CompressNone   Compression = 0
+	CompressSnappy Compression = 1
+	CompressZstd   Compression = 2
+)
+
+var compressionNames = map[string]Compression{
+	"none":   CompressNone,
+	"snappy": CompressSnappy,
+	"zstd":   CompressZstd,
+}
+
+func (c Compression) String() string {
+	for name, compression := range compressionNames {
+		if compression == c {
+			return name
+		}
+	}
+	return fmt.Sprintf("compression(%d)", byte(c))
+}
+
+func ParseCompression(name string) (Compression, error) {
+	if name == "" {
+		return CompressNone, nil
+	}
+	if compression, ok := compressionNames[name]; ok {
+		return compression, nil
+	}
+	return 0, fmt.Errorf("cache: unknown compression %q (none|snappy|zstd)", name)
+}
+
+// Format is how the adapters store values: which codec, and which
+// compression for payloads of at least Threshold bytes.
+type Format struct {
+	Codec       CodecID
+	Compression Compression
+	Threshold   int
+}
+
+var (
+	ErrFrameVersion = errors.New("cache: unknown frame version")
+	ErrFrameCodec   = errors.New("cache: frame written by another codec")
+)
+
+// zstd encoders/decoders are expensive to build and safe for concurrent
+// EncodeAll/DecodeAll, so one of each is shared.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+type framed[T any] struct {
+	id     CodecID
+	codec  Codec[T]
+	format Format
+}
+
+// NewCodec returns the standard codec named by format, framed.
+func NewCodec[T any](format Format) Codec[T] {
+	codec, ok := standardCodec[T](format.Codec)
+	if !ok {
+		format.Codec = CodecJSON
+		codec = jsonCodec[T]{}
+	}
+	return framed[T]{id: format.Codec, codec: codec, format: format}
+}
+
+// Frame wraps an adapter specific codec, so it gets the version byte and
+// compression too.
+func Frame[T any](codec Codec[T], format Format) Codec[T] {
+	return framed[T]{id: CodecCustom, codec: codec, format: format}
+}
+
+func (f framed[T]) Marshal(value T) ([]byte, error) {
+	payload, err := f.codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	compression := f.format.Compression
+	if len(payload) < f.format.Threshold {
+		compression = CompressNone
+	}
+
+	header := []byte{FrameVersion, byte(f.id), byte(compression)}
+	switch compression {
+	case CompressSnappy:
+		return append(header, snappy.Encode(nil, payload)...), nil
+	case CompressZstd:
+		return zstdEncoder.EncodeAll(payload, header), nil
+	}
+	return append(header, payload...), nil
+}
+
+func (f framed[T]) Unmarshal(data []byte) (value T, err error) {
+	info, payload, err := ReadFrame(data)
+	if err != nil {
+		return value, err
+	}
+
+	codec := f.codec
+	if info.Codec != f.id {
+		var ok bool
+		if codec, ok = standardCodec[T](info.Codec); !ok {
+			return value, ErrFrameCodec
+		}
+	}
+	return codec.Unmarshal(payload)
+}
+
+// FrameInfo describes a stored payload without decoding it.
+type FrameInfo struct {
+	Version     byte
+	Codec       CodecID
+	Compression Compression
+}
+
+// ReadFrame checks the header and returns the decompressed payload.
+func ReadFrame(data []byte) (info FrameInfo, payload []byte, err error) {
+	if len(data) < frameHeader || data[0] != FrameVersion {
+		return info, nil, ErrFrameVersion
+	}
+	info = FrameInfo{Version: data[0], Codec: CodecID(data[1]), Compression: Compression(data[2])}
+
+	payload = data[frameHeader:]
+	switch info.Compression {
+	case CompressNone:
+	case CompressSnappy:
+		payload, err = snappy.Decode(nil, payload)
+	case CompressZstd:
+		payload, err = zstdDecoder.DecodeAll(payload, nil)
+	default:
+		err = fmt.Errorf("cache: unknown compression %d", info.Compression)
+	}
+	return info, payload, err
+}
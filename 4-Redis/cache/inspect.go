@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+//	inspection (admin API)
+
+// KeyInfo describes a stored key without decoding its value.
+type KeyInfo struct {
+	Key   string `json:"key"`
+	Layer string `json:"layer"`
+	Type  string `json:"type"`
+	// TTL is in milliseconds, -1 when the key does not expire.
+	TTL         int64      `json:"ttl_ms"`
+	Size        int64      `json:"size"`
+	Codec       string     `json:"codec,omitempty"`
+	Compression string     `json:"compression,omitempty"`
+	SoftExpiry  *time.Time `json:"soft_expiry,omitempty"`
+}
+
+// ErrNoDecoder is returned for values written by a codec that needs the
+// Go type (gob, protobuf, custom) when no cache of that family runs here.
+var ErrNoDecoder = errors.New("cache: no decoder for this key on this instance")
+
+// decoders hold, per key family, how the Aside of that family reads its
+// values back, so the admin API shows exactly what a request would get.
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]func(data []byte) (interface{}, error){}
+)
+
+func registerDecoder(family string, decode func(data []byte) (interface{}, error)) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[family] = decode
+}
+
+// decoderFor returns the decoder of the longest family key belongs to.
+func decoderFor(key string) (decode func(data []byte) (interface{}, error), ok bool) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	best := ""
+	for family, d := range decoders {
+		if (key == family || strings.HasPrefix(key, family+"::")) && len(family) > len(best) {
+			best, decode = family, d
+		}
+	}
+	return decode, decode != nil
+}
+
+// peek is how many bytes describe a value: envelope and frame header.
+const peek = envelopeHeader + frameHeader
+
+// describe reads the frame header, behind the envelope when there is one.
+// An envelope starts with a unix ms timestamp, whose first byte is 0 for
+// the next two thousand years, so it cannot be taken for a frame version.
+func describe(data []byte) (info FrameInfo, soft time.Time, ok bool) {
+	switch {
+	case len(data) >= frameHeader && data[0] == FrameVersion:
+	case len(data) >= peek && data[envelopeHeader] == FrameVersion:
+		_, soft, _, _ = unwrap(data)
+		data = data[envelopeHeader:]
+	default:
+		return info, soft, false
+	}
+	return FrameInfo{Version: data[0], Codec: CodecID(data[1]), Compression: Compression(data[2])}, soft, true
+}
+
+// InspectKeys describes keys with one pipeline; keys that are gone by then
+// are left out.
+func InspectKeys(ctx context.Context, redisClient redis.UniversalClient, keys []string) ([]KeyInfo, error) {
+	pipe := redisClient.Pipeline()
+	types := make([]*redis.StatusCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	sizes := make([]*redis.IntCmd, len(keys))
+	heads := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		types[i] = pipe.Type(ctx, key)
+		ttls[i] = pipe.PTTL(ctx, key)
+		sizes[i] = pipe.StrLen(ctx, key)
+		heads[i] = pipe.GetRange(ctx, key, 0, peek-1)
+	}
+	// a non-string key fails STRLEN / GETRANGE, TYPE tells it apart
+	if _, err := pipe.Exec(ctx); err != nil && !isReplyError(err) {
+		return nil, err
+	}
+
+	infos := make([]KeyInfo, 0, len(keys))
+	for i, key := range keys {
+		if types[i].Val() == "none" {
+			continue
+		}
+		info := KeyInfo{Key: key, Layer: layerOf(key), Type: types[i].Val(), TTL: -1}
+		if ttl := ttls[i].Val(); ttl > 0 {
+			info.TTL = ttl.Milliseconds()
+		}
+		if info.Type == "string" {
+			info.Size = sizes[i].Val()
+			describeInto(&info, []byte(heads[i].Val()))
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// DecodeKey returns the description and the decoded value of key. Values
+// that are not frames (locks) are returned as strings.
+func DecodeKey(ctx context.Context, redisClient redis.UniversalClient, key string) (info KeyInfo, value interface{}, err error) {
+	infos, err := InspectKeys(ctx, redisClient, []string{key})
+	if err != nil {
+		return info, nil, err
+	}
+	if len(infos) == 0 {
+		return info, nil, redis.Nil
+	}
+	info = infos[0]
+
+	data, err := redisClient.Get(ctx, key).Bytes()
+	if err != nil {
+		return info, nil, err
+	}
+	frame, _, ok := describe(data)
+	if !ok {
+		return info, string(data), nil
+	}
+	if decode, ok := decoderFor(key); ok {
+		value, err = decode(data)
+		return info, value, err
+	}
+
+	// no cache of this family here: only self-describing codecs can be read
+	_, payload, err := ReadFrame(stripEnvelope(data))
+	if err != nil {
+		return info, nil, err
+	}
+	switch frame.Codec {
+	case CodecJSON:
+		return info, json.RawMessage(payload), nil
+	case CodecMsgPack:
+		err = msgpack.Unmarshal(payload, &value)
+		return info, value, err
+	}
+	return info, nil, ErrNoDecoder
+}
+
+// stripEnvelope returns the frame of a stored value.
+func stripEnvelope(data []byte) []byte {
+	if len(data) >= peek && data[0] != FrameVersion && data[envelopeHeader] == FrameVersion {
+		return data[envelopeHeader:]
+	}
+	return data
+}
+
+func describeInto(info *KeyInfo, head []byte) {
+	frame, soft, ok := describe(head)
+	if !ok {
+		return
+	}
+	info.Codec = frame.Codec.String()
+	info.Compression = frame.Compression.String()
+	if !soft.IsZero() {
+		info.SoftExpiry = &soft
+	}
+}
+
+// layerOf is the first segment of a key, e.g. "service".
+func layerOf(key string) string {
+	layer, _, _ := strings.Cut(key, "::")
+	return layer
+}
+
+func isReplyError(err error) bool {
+	var redisErr redis.Error
+	return errors.As(err, &redisErr)
+}
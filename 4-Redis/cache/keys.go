@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v9"
+)
+
+//	keys
+
+const (
+	RepositoryGetProducts = "repository::GetProducts"
+	ServiceGetProducts    = "service::GetProducts"
+	HandlerGetProducts    = "handler::GetProducts"
+
+	// one key per product id, e.g. "service::GetProduct::42"
+	RepositoryGetProduct = "repository::GetProduct"
+	ServiceGetProduct    = "service::GetProduct"
+	HandlerGetProduct    = "handler::GetProduct"
+)
+
+// ProductKeys are the prefixes of every key that can hold a copy of a
+// product listing, whichever layer cached it.
+var ProductKeys = []string{
+	RepositoryGetProducts,
+	ServiceGetProducts,
+	HandlerGetProducts,
+}
+
+// ProductDetailKeys are the families holding a copy of one product.
+var ProductDetailKeys = []string{
+	RepositoryGetProduct,
+	ServiceGetProduct,
+	HandlerGetProduct,
+}
+
+// ProductKey is the key of product id in a ProductDetailKeys family.
+func ProductKey(family string, id int) string {
+	return Key(family, strconv.Itoa(id))
+}
+
+// Tag makes part a cluster hash tag: keys with the same tag are stored in
+// the same slot, which multi-key commands, scripts and MULTI need.
+func Tag(part string) string {
+	return "{" + part + "}"
+}
+
+// Key joins a key family and its canonical parts, e.g.
+// "service::GetProducts::limit=20&offset=0&order=desc&sort=quantity".
+func Key(prefix string, parts ...string) string {
+	return strings.Join(append([]string{prefix}, parts...), "::")
+}
+
+//	invalidation
+
+// InvalidateProducts drops every cached listing page, and the cached copies
+// of the products ids (missing ones included), from every layer, in redis
+// and in the L1 of every instance, through their tags. It is called after a
+// write has been committed, so a failure is logged rather than returned: the
+// data is already saved and the TTL bounds the staleness. For the same reason
+// callers pass a context of their own rather than the request's, whose
+// deadline may be spent by then.
+func InvalidateProducts(ctx context.Context, redisClient redis.UniversalClient, ids ...int) error {
+	return invalidateTags(ctx, redisClient, append(ProductTags(ids...), ListTag)...)
+}
+
+// InvalidateProductFields is InvalidateProducts for a write that changed
+// fields of existing products: of the listings, only the pages showing one
+// of them or filtered / sorted on one of the fields are dropped.
+func InvalidateProductFields(ctx context.Context, redisClient redis.UniversalClient, fields []string, ids ...int) error {
+	tags := ProductTags(ids...)
+	for _, field := range fields {
+		tags = append(tags, ListFieldTag(field))
+	}
+	return invalidateTags(ctx, redisClient, tags...)
+}
+
+func invalidateTags(ctx context.Context, redisClient redis.UniversalClient, tags ...string) error {
+	if _, err := InvalidateTags(ctx, redisClient, tags...); err != nil {
+		log.Println("cache: invalidate products:", err)
+		return err
+	}
+	return nil
+}
+
+// InvalidateCatalog drops every cached listing and product, for when the
+// writes could not say what they changed (after a redis outage): it scans
+// the families instead of going through the tags.
+func InvalidateCatalog(ctx context.Context, redisClient redis.UniversalClient) error {
+	if err := invalidateFamilies(ctx, redisClient, ProductKeys); err != nil {
+		return err
+	}
+	return invalidateFamilies(ctx, redisClient, ProductDetailKeys)
+}
+
+func invalidateFamilies(ctx context.Context, redisClient redis.UniversalClient, families []string) error {
+	for _, prefix := range families {
+		if err := DeletePrefix(ctx, redisClient, prefix); err != nil {
+			log.Println("cache: invalidate products:", err)
+			return err
+		}
+		if err := invalidateLocal(ctx, redisClient, prefix); err != nil {
+			log.Println("cache: invalidate products:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// DeletePrefix removes the key family itself and every key below it. Keys
+// are found with SCAN so a large keyspace never blocks Redis, and deleted
+// one per command (pipelined): a cluster cannot DEL keys of several slots.
+func DeletePrefix(ctx context.Context, redisClient redis.UniversalClient, prefix string) error {
+	_, err := deleteMatching(ctx, redisClient, []string{prefix}, Key(prefix, "*"))
+	return err
+}
+
+// FlushKey deletes key and the keys below it (last good copy, lock) in
+// redis and in the L1 of every instance, and returns how many redis keys
+// were deleted.
+func FlushKey(ctx context.Context, redisClient redis.UniversalClient, key string) (int64, error) {
+	deleted, err := deleteMatching(ctx, redisClient, []string{key}, EscapePattern(Key(key, ""))+"*")
+	if err != nil {
+		return deleted, err
+	}
+	return deleted, invalidateLocal(ctx, redisClient, key)
+}
+
+// FlushPrefix deletes every key starting with prefix, like FlushKey.
+func FlushPrefix(ctx context.Context, redisClient redis.UniversalClient, prefix string) (int64, error) {
+	deleted, err := deleteMatching(ctx, redisClient, nil, EscapePattern(prefix)+"*")
+	if err != nil {
+		return deleted, err
+	}
+	return deleted, invalidateLocalPrefix(ctx, redisClient, prefix)
+}
+
+// deleteMatching deletes keys and every key matching pattern, by batches of
+// 100, and returns how many existed.
+func deleteMatching(ctx context.Context, redisClient redis.UniversalClient, keys []string, pattern string) (deleted int64, err error) {
+	del := func() error {
+		pipe := redisClient.Pipeline()
+		cmds := make([]*redis.IntCmd, len(keys))
+		for i, key := range keys {
+			cmds[i] = pipe.Del(ctx, key)
+		}
+		keys = keys[:0]
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+		for _, cmd := range cmds {
+			deleted += cmd.Val()
+		}
+		return nil
+	}
+
+	err = ScanKeys(ctx, redisClient, pattern, func(key string) error {
+		keys = append(keys, key)
+		if len(keys) >= 100 {
+			return del()
+		}
+		return nil
+	})
+	if err != nil {
+		return deleted, err
+	}
+	if len(keys) == 0 {
+		return deleted, nil
+	}
+	return deleted, del()
+}
+
+// EscapePattern escapes the glob characters of s for SCAN MATCH: listing
+// keys hold user input (name prefixes).
+func EscapePattern(s string) string {
+	return globEscaper.Replace(s)
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// ScanKeys calls fn (never concurrently) for every key matching pattern. On
+// a cluster every master is scanned, SCAN only walks the node it is sent to.
+func ScanKeys(ctx context.Context, redisClient redis.UniversalClient, pattern string, fn func(key string) error) error {
+	cluster, ok := redisClient.(*redis.ClusterClient)
+	if !ok {
+		return scanNode(ctx, redisClient, pattern, fn)
+	}
+
+	mu := sync.Mutex{}
+	return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		return scanNode(ctx, node, pattern, func(key string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return fn(key)
+		})
+	})
+}
+
+func scanNode(ctx context.Context, node redis.Cmdable, pattern string, fn func(key string) error) error {
+	iter := node.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		if err := fn(iter.Val()); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
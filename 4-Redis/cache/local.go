@@ -0,0 +1,230 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+//	L1 : in-process LRU in front of redis
+
+// InvalidateChannel carries what to drop from every instance's L1: "key:"
+// and a key (the keys below it included) or "prefix:" and a raw prefix.
+const InvalidateChannel = "cache::invalidate"
+
+const (
+	invalidateKey    = "key:"
+	invalidatePrefix = "prefix:"
+)
+
+// Local is a bounded, TTL-limited LRU shared by the Aside caches of one
+// process. Values are kept decoded, so an L1 hit costs no network and no
+// unmarshalling. Writes on any instance evict entries through Listen.
+type Local struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // front = most recently used
+	entries map[string]*list.Element
+}
+
+type localEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+var (
+	localsMu sync.Mutex
+	locals   []*Local
+)
+
+func NewLocal(size int, ttl time.Duration) *Local {
+	l := &Local{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+	localsMu.Lock()
+	locals = append(locals, l)
+	localsMu.Unlock()
+	return l
+}
+
+func (l *Local) Get(key string) (interface{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := element.Value.(*localEntry)
+	if time.Now().After(e.expires) {
+		l.remove(element)
+		return nil, false
+	}
+	l.order.MoveToFront(element)
+	return e.value, true
+}
+
+// Set stores value for the L1 TTL, or until expires if that is sooner.
+func (l *Local) Set(key string, value interface{}, expires time.Time) {
+	if limit := time.Now().Add(l.ttl); expires.IsZero() || limit.Before(expires) {
+		expires = limit
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.entries[key]; ok {
+		element.Value = &localEntry{key: key, value: value, expires: expires}
+		l.order.MoveToFront(element)
+		return
+	}
+	l.entries[key] = l.order.PushFront(&localEntry{key: key, value: value, expires: expires})
+	for l.order.Len() > l.size {
+		l.remove(l.order.Back())
+	}
+}
+
+// DeleteKey drops key and the keys below it ("<key>::..."), not the keys it
+// is only a prefix of: product 4 is not product 42.
+func (l *Local) DeleteKey(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	below := Key(key, "")
+	for k, element := range l.entries {
+		if k == key || strings.HasPrefix(k, below) {
+			l.remove(element)
+		}
+	}
+}
+
+func (l *Local) DeletePrefix(prefix string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, element := range l.entries {
+		if strings.HasPrefix(key, prefix) {
+			l.remove(element)
+		}
+	}
+}
+
+func (l *Local) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.order.Init()
+	l.entries = map[string]*list.Element{}
+}
+
+func (l *Local) remove(element *list.Element) {
+	l.order.Remove(element)
+	delete(l.entries, element.Value.(*localEntry).key)
+}
+
+// Listen applies invalidations published by other instances until ctx is
+// done. After a (re)subscribe messages may have been missed, so the whole L1
+// is dropped.
+func (l *Local) Listen(ctx context.Context, redisClient redis.UniversalClient) {
+	pubsub := redisClient.Subscribe(ctx, InvalidateChannel)
+	// a blocked receive does not see ctx, closing the subscription ends it
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+
+	for {
+		// an explicit timeout: Receive keeps the last read deadline of the
+		// connection, i.e. the client's short read timeout, and reconnects
+		msg, err := pubsub.ReceiveTimeout(ctx, time.Minute)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// a quiet channel; the pong (or the error) is the next message
+				pubsub.Ping(ctx)
+				continue
+			}
+			log.Println("cache: invalidation channel:", err)
+			l.Flush()
+			time.Sleep(time.Second)
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			l.Flush()
+		case *redis.Message:
+			l.apply(msg.Payload)
+		}
+	}
+}
+
+// apply evicts what an invalidation message names; a message it cannot read
+// drops the whole L1.
+func (l *Local) apply(message string) {
+	switch {
+	case strings.HasPrefix(message, invalidateKey):
+		l.DeleteKey(strings.TrimPrefix(message, invalidateKey))
+		return
+	case strings.HasPrefix(message, invalidatePrefix):
+		l.DeletePrefix(strings.TrimPrefix(message, invalidatePrefix))
+		return
+	}
+	log.Printf("cache: invalidation channel: unknown message %q", message)
+	l.Flush()
+}
+
+//	invalidation
+
+// invalidateLocal evicts keys, and the keys below them, from this process
+// right away and from every other instance through pub/sub.
+func invalidateLocal(ctx context.Context, redisClient redis.UniversalClient, keys ...string) error {
+	messages := make([]string, len(keys))
+	for i, key := range keys {
+		messages[i] = invalidateKey + key
+	}
+	return publishLocal(ctx, redisClient, messages)
+}
+
+// invalidateLocalPrefix is invalidateLocal for every key starting with
+// prefix.
+func invalidateLocalPrefix(ctx context.Context, redisClient redis.UniversalClient, prefix string) error {
+	return publishLocal(ctx, redisClient, []string{invalidatePrefix + prefix})
+}
+
+func publishLocal(ctx context.Context, redisClient redis.UniversalClient, messages []string) error {
+	localsMu.Lock()
+	for _, l := range locals {
+		for _, message := range messages {
+			l.apply(message)
+		}
+	}
+	localsMu.Unlock()
+	switch len(messages) {
+	case 0:
+		return nil
+	case 1:
+		return redisClient.Publish(ctx, InvalidateChannel, messages[0]).Err()
+	}
+	pipe := redisClient.Pipeline()
+	for _, message := range messages {
+		pipe.Publish(ctx, InvalidateChannel, message)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestLocal is a Local that is not registered with the package, so only
+// its own calls (and Listen) reach it.
+func newTestLocal() *Local {
+	return &Local{size: 10, ttl: time.Minute, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func localKeys(l *Local) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keys := []string{}
+	for key := range l.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestLocalApply(t *testing.T) {
+	keys := []string{
+		"service::GetProduct::4",
+		"service::GetProduct::42",
+		"service::GetProduct::4::stale",
+		"service::GetProducts::limit=20",
+	}
+
+	tests := []struct {
+		name    string
+		message string
+		want    []string
+	}{
+		{
+			name:    "key",
+			message: invalidateKey + "service::GetProduct::4",
+			want:    []string{"service::GetProduct::42", "service::GetProducts::limit=20"},
+		},
+		{
+			name:    "family",
+			message: invalidateKey + "service::GetProduct",
+			want:    []string{"service::GetProducts::limit=20"},
+		},
+		{
+			name:    "prefix",
+			message: invalidatePrefix + "service::GetProduct::4",
+			want:    []string{"service::GetProducts::limit=20"},
+		},
+		{
+			name:    "unknown key",
+			message: invalidateKey + "service::GetProduct::5",
+			want:    keys,
+		},
+		{
+			name:    "unknown message",
+			message: "service::GetProduct::4",
+			want:    []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLocal()
+			for _, key := range keys {
+				l.Set(key, key, time.Time{})
+			}
+			l.apply(tt.message)
+			if got := localKeys(l); !equal(got, tt.want) {
+				t.Fatalf("keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalListen(t *testing.T) {
+	mr, redisClient := newTestRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := newTestLocal()
+	go l.Listen(ctx, redisClient)
+	for deadline := time.Now().Add(time.Second); mr.PubSubNumSub(InvalidateChannel)[InvalidateChannel] == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Listen did not subscribe")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond) // past the flush of the subscription
+	l.Set("handler::GetProduct::4", "4", time.Time{})
+	l.Set("handler::GetProduct::42", "42", time.Time{})
+
+	if err := invalidateLocal(ctx, redisClient, "handler::GetProduct::4"); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, ok := l.Get("handler::GetProduct::4"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the published key was not evicted")
+		}
+	}
+	if _, ok := l.Get("handler::GetProduct::42"); !ok {
+		t.Fatal("product 42 was evicted with product 4")
+	}
+}
@@ -0,0 +1,49 @@
+package cache
+
+import "google.golang.org/protobuf/encoding/protowire"
+
+//	protowire helpers for ProtoMessage implementations
+
+func AppendProtoVarint(buf []byte, num protowire.Number, value int64) []byte {
+	buf = protowire.AppendTag(buf, num, protowire.VarintType)
+	return protowire.AppendVarint(buf, uint64(value))
+}
+
+func AppendProtoBytes(buf []byte, num protowire.Number, value []byte) []byte {
+	buf = protowire.AppendTag(buf, num, protowire.BytesType)
+	return protowire.AppendBytes(buf, value)
+}
+
+// ConsumeProtoFields calls fn for every varint and length-delimited field of
+// a message; other wire types are skipped.
+func ConsumeProtoFields(data []byte, fn func(num protowire.Number, value uint64, bytes []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var value uint64
+		var bytes []byte
+		switch typ {
+		case protowire.VarintType:
+			value, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			if err := fn(num, value, bytes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package cache
+
+import "time"
+
+//	settings
+
+// Settings are the knobs shared by the redis adapters of every layer; the
+// adapters add their own name, key and codec on top.
+type Settings struct {
+	TTL          time.Duration
+	Stale        time.Duration
+	EarlyRefresh float64
+	Local        *Local // optional L1
+	Format       Format // codec and compression of stored values
+	// MissingTTL is how long an unknown id is remembered as such
+	MissingTTL time.Duration
+}
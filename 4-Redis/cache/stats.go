@@ -0,0 +1,39 @@
+package cache
+
+import "expvar"
+
+//	stats (served by GET /debug/vars)
+
+// stats counts, per cache name, how often the loader ran and how many loader
+// calls the stampede protection saved. Every counter under "avoided" is a
+// database (or service) call that did not happen.
+var stats = expvar.NewMap("cache")
+
+const (
+	statLoads      = "loads"      // loader calls (database / service)
+	statCoalesced  = "coalesced"  // callers that shared another caller's load in this process
+	statLockWaits  = "lock_waits" // callers that waited for another instance's rebuild
+	statLastServed = "last_value" // callers that got the last value while a rebuild ran
+	statAvoided    = "avoided"    // sum of the three above
+
+	statStale          = "stale"           // stale values served while refreshing
+	statRefreshes      = "refreshes"       // background refreshes of stale entries
+	statEarlyRefreshes = "early_refreshes" // background refreshes started before expiry
+	statWarms          = "warms"           // loads ahead of the requests (warm-up, scheduled refresh)
+	statMissing        = "missing"         // known-missing results served from the cache (negative caching)
+
+	statL1Hits   = "l1_hits" // served from the in-process LRU
+	statL1Misses = "l1_misses"
+	statL2Hits   = "l2_hits" // served from redis
+	statL2Misses = "l2_misses"
+
+	statBypassed = "bypassed" // redis calls skipped because the circuit was open
+)
+
+func count(name string, stat string) {
+	stats.Add(name+"."+stat, 1)
+	switch stat {
+	case statCoalesced, statLockWaits, statLastServed:
+		stats.Add(name+"."+statAvoided, 1)
+	}
+}
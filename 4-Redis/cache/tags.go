@@ -0,0 +1,278 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+//	tags
+
+// A tag names what a cached entry was built from, e.g. "product:42", so a
+// write drops the entries it made stale and only those. Each tag is a set
+// of the keys carrying it ({tag::product:42}); Aside adds a key to its tags
+// before storing it (Options.Tags), so an entry never exists untagged.
+
+// ListTag is carried by every listing page: rows added or removed shift
+// them all.
+const ListTag = "catalog:list"
+
+// ProductTag is carried by every entry holding a copy of product id: its
+// detail, missing or not, and the listing pages it is on.
+func ProductTag(id int) string {
+	return "product:" + strconv.Itoa(id)
+}
+
+// ProductTags are the tags of the products ids.
+func ProductTags(ids ...int) []string {
+	tags := make([]string, 0, len(ids)+1)
+	for _, id := range ids {
+		tags = append(tags, ProductTag(id))
+	}
+	return tags
+}
+
+// CategoryTag is carried by the listing pages of category id.
+func CategoryTag(id int) string {
+	return "category:" + strconv.Itoa(id)
+}
+
+// ListFieldTag is carried by the listings whose filter or order reads field
+// (a product column), e.g. "catalog:list:quantity": writing that field can
+// move any product in or out of them.
+func ListFieldTag(field string) string {
+	return ListTag + ":" + field
+}
+
+func tagKey(tag string) string {
+	return Tag(Key("tag", tag))
+}
+
+// tagScript adds ARGV[2..] to the set KEYS[1] and keeps the set ARGV[1] ms
+// at least, so it lives as long as the longest lived key it names.
+var tagScript = redis.NewScript(`
+redis.call("SADD", KEYS[1], unpack(ARGV, 2))
+if redis.call("PTTL", KEYS[1]) < tonumber(ARGV[1]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// tagKeys queues the tagging of keys, which are kept ttl, ahead of their SET
+// in pipe. Each tag is one script (a set lives in the slot of its tag).
+func tagKeys(ctx context.Context, pipe redis.Pipeliner, tags []string, ttl time.Duration, keys ...string) {
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, ttl.Milliseconds())
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	for _, tag := range tags {
+		// EVALSHA would fail with NOSCRIPT inside a pipeline, without a retry
+		tagScript.Eval(ctx, pipe, []string{tagKey(tag)}, args...)
+	}
+}
+
+//	invalidation
+
+// invalidateTagsScript deletes every key of the sets KEYS and the sets, in one
+// step, and returns how many keys existed and the keys.
+var invalidateTagsScript = redis.NewScript(`
+local deleted, keys = 0, {}
+for _, tag in ipairs(KEYS) do
+	for _, key in ipairs(redis.call("SMEMBERS", tag)) do
+		deleted = deleted + redis.call("DEL", key)
+		keys[#keys + 1] = key
+	end
+	redis.call("DEL", tag)
+end
+return {deleted, keys}
+`)
+
+// InvalidateTags deletes every key carrying one of tags, in redis and in the
+// L1 of every instance, and returns how many redis keys existed. On a single
+// node (or Sentinel) one script deletes them and the tag sets, so no reader
+// sees half of a tag invalidated. A cluster spreads the keys of a tag over
+// many slots, which a script cannot reach: there the sets are emptied with
+// SPOP (a key tagged meanwhile is popped too, or stays for the next time)
+// and the keys deleted one by one.
+func InvalidateTags(ctx context.Context, redisClient redis.UniversalClient, tags ...string) (int64, error) {
+	if len(tags) == 0 {
+		return 0, nil
+	}
+
+	var deleted int64
+	var keys []string
+	var err error
+	if cluster, ok := redisClient.(*redis.ClusterClient); ok {
+		deleted, keys, err = popTagged(ctx, cluster, tags)
+	} else {
+		deleted, keys, err = deleteTagged(ctx, redisClient, tags)
+	}
+	if err != nil {
+		return deleted, err
+	}
+	return deleted, invalidateLocal(ctx, redisClient, unique(keys)...)
+}
+
+func deleteTagged(ctx context.Context, redisClient redis.UniversalClient, tags []string) (int64, []string, error) {
+	tagKeys := make([]string, len(tags))
+	for i, tag := range tags {
+		tagKeys[i] = tagKey(tag)
+	}
+	reply, err := invalidateTagsScript.Run(ctx, redisClient, tagKeys).Slice()
+	if err != nil {
+		return 0, nil, err
+	}
+	deleted, _ := reply[0].(int64)
+	members, _ := reply[1].([]interface{})
+	keys := make([]string, 0, len(members))
+	for _, member := range members {
+		if key, ok := member.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return deleted, keys, nil
+}
+
+func popTagged(ctx context.Context, cluster *redis.ClusterClient, tags []string) (deleted int64, keys []string, err error) {
+	for _, tag := range tags {
+		for {
+			popped, err := cluster.SPopN(ctx, tagKey(tag), 100).Result()
+			if err != nil {
+				return deleted, keys, err
+			}
+			if len(popped) == 0 {
+				break
+			}
+			pipe := cluster.Pipeline()
+			cmds := make([]*redis.IntCmd, len(popped))
+			for i, key := range popped {
+				cmds[i] = pipe.Del(ctx, key)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return deleted, keys, err
+			}
+			for _, cmd := range cmds {
+				deleted += cmd.Val()
+			}
+			keys = append(keys, popped...)
+		}
+	}
+	return deleted, keys, nil
+}
+
+func unique(keys []string) []string {
+	seen := map[string]bool{}
+	result := keys[:0]
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+//	cleanup
+
+// PruneTags removes from every tag set the keys that have expired, which a
+// set otherwise keeps until its tag is invalidated or the set expires, and
+// returns how many. A key stored again in the meantime is put back: Aside
+// tags and stores a key in one MULTI, so it either was in the set before the
+// SREM or exists by the second check.
+func PruneTags(ctx context.Context, redisClient redis.UniversalClient) (int64, error) {
+	var pruned int64
+	err := ScanKeys(ctx, redisClient, "{"+Key("tag", "*"), func(tag string) error {
+		members, err := redisClient.SMembers(ctx, tag).Result()
+		if err != nil {
+			return err
+		}
+		gone, err := missingKeys(ctx, redisClient, members)
+		if err != nil || len(gone) == 0 {
+			return err
+		}
+		if err := redisClient.SRem(ctx, tag, toArgs(gone)...).Err(); err != nil {
+			return err
+		}
+
+		//	stored between the check and the SREM : tag it again
+		expired, err := missingKeys(ctx, redisClient, gone)
+		if err != nil {
+			return err
+		}
+		if len(expired) < len(gone) {
+			if err := redisClient.SAdd(ctx, tag, toArgs(except(gone, expired))...).Err(); err != nil {
+				return err
+			}
+		}
+		pruned += int64(len(expired))
+		return nil
+	})
+	return pruned, err
+}
+
+// missingKeys are the keys that do not exist (one EXISTS per key: they live
+// in any slot).
+func missingKeys(ctx context.Context, redisClient redis.UniversalClient, keys []string) ([]string, error) {
+	pipe := redisClient.Pipeline()
+	cmds := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Exists(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	missing := []string{}
+	for i, cmd := range cmds {
+		if cmd.Val() == 0 {
+			missing = append(missing, keys[i])
+		}
+	}
+	return missing, nil
+}
+
+// PruneTagsEvery runs PruneTags every interval until ctx is done.
+func PruneTagsEvery(ctx context.Context, redisClient redis.UniversalClient, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pruned, err := PruneTags(ctx, redisClient)
+			if err != nil {
+				log.Println("cache: prune tags:", err)
+				continue
+			}
+			if pruned > 0 {
+				log.Printf("cache: pruned %v expired keys from the tags", pruned)
+			}
+		}
+	}
+}
+
+func except(keys []string, removed []string) []string {
+	skip := map[string]bool{}
+	for _, key := range removed {
+		skip[key] = true
+	}
+	result := []string{}
+	for _, key := range keys {
+		if !skip[key] {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+func toArgs(keys []string) []interface{} {
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	return args
+}
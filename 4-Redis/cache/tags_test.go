@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestInvalidateTags(t *testing.T) {
+	// key -> its tags
+	stored := map[string][]string{
+		"listing::top":      {ListTag, ListFieldTag("quantity"), ProductTag(1), ProductTag(2)},
+		"listing::by-name":  {ListTag, ListFieldTag("name"), ProductTag(2), ProductTag(3)},
+		"listing::category": {ListTag, CategoryTag(7), ProductTag(3)},
+		"product::1":        {ProductTag(1)},
+		"product::2":        {ProductTag(2)},
+		"product::3":        {ProductTag(3)},
+	}
+
+	tests := []struct {
+		name        string
+		tags        []string
+		wantDeleted int64
+		wantLeft    []string
+	}{
+		{name: "no tags", wantLeft: []string{"listing::by-name", "listing::category", "listing::top", "product::1", "product::2", "product::3"}},
+		{name: "unknown tag", tags: []string{ProductTag(42)}, wantLeft: []string{"listing::by-name", "listing::category", "listing::top", "product::1", "product::2", "product::3"}},
+		{name: "one product", tags: []string{ProductTag(1)}, wantDeleted: 2, wantLeft: []string{"listing::by-name", "listing::category", "product::2", "product::3"}},
+		{name: "a field", tags: []string{ListFieldTag("name")}, wantDeleted: 1, wantLeft: []string{"listing::category", "listing::top", "product::1", "product::2", "product::3"}},
+		{name: "a category", tags: []string{CategoryTag(7)}, wantDeleted: 1, wantLeft: []string{"listing::by-name", "listing::top", "product::1", "product::2", "product::3"}},
+		{name: "every listing and a product", tags: []string{ListTag, ProductTag(2)}, wantDeleted: 4, wantLeft: []string{"product::1", "product::3"}},
+		{name: "overlapping tags count keys once", tags: []string{ProductTag(2), ProductTag(3)}, wantDeleted: 5, wantLeft: []string{"product::1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, redisClient := newTestRedis(t)
+			ctx := context.Background()
+			for key, tags := range stored {
+				pipe := redisClient.TxPipeline()
+				tagKeys(ctx, pipe, tags, time.Minute, key)
+				pipe.Set(ctx, key, "value", time.Minute)
+				if _, err := pipe.Exec(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			deleted, err := InvalidateTags(ctx, redisClient, tt.tags...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != tt.wantDeleted {
+				t.Fatalf("deleted = %d, want %d", deleted, tt.wantDeleted)
+			}
+
+			left := []string{}
+			for key := range stored {
+				if mr.Exists(key) {
+					left = append(left, key)
+				}
+			}
+			sort.Strings(left)
+			if !equal(left, tt.wantLeft) {
+				t.Fatalf("left = %v, want %v", left, tt.wantLeft)
+			}
+			for _, tag := range tt.tags {
+				if mr.Exists(tagKey(tag)) {
+					t.Fatalf("tag set %v still exists", tagKey(tag))
+				}
+			}
+		})
+	}
+}
+
+func TestTagKeysTTL(t *testing.T) {
+	mr, redisClient := newTestRedis(t)
+	ctx := context.Background()
+
+	// a set lives as long as the longest lived key it names
+	for _, ttl := range []time.Duration{time.Minute, time.Hour, time.Second} {
+		pipe := redisClient.TxPipeline()
+		tagKeys(ctx, pipe, []string{ListTag}, ttl, "key::"+ttl.String())
+		if _, err := pipe.Exec(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ttl := mr.TTL(tagKey(ListTag)); ttl != time.Hour {
+		t.Fatalf("tag set ttl = %v, want 1h", ttl)
+	}
+	members, _ := mr.Members(tagKey(ListTag))
+	if len(members) != 3 {
+		t.Fatalf("members = %v, want 3", members)
+	}
+}
+
+func TestPruneTags(t *testing.T) {
+	mr, redisClient := newTestRedis(t)
+	ctx := context.Background()
+
+	pipe := redisClient.TxPipeline()
+	tagKeys(ctx, pipe, []string{ProductTag(1)}, time.Hour, "kept", "expired")
+	pipe.Set(ctx, "kept", "value", time.Hour)
+	pipe.Set(ctx, "expired", "value", time.Minute)
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(2 * time.Minute)
+
+	pruned, err := PruneTags(ctx, redisClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 {
+		t.Fatalf("pruned = %d, want 1", pruned)
+	}
+	if members, _ := mr.Members(tagKey(ProductTag(1))); !equal(members, []string{"kept"}) {
+		t.Fatalf("members = %v, want [kept]", members)
+	}
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"goredis/app"
+	"goredis/cache"
+	"goredis/config"
+	"goredis/repositories"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v9"
+	"github.com/valyala/fasthttp"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+//	Load test of GET /products for every cache layer, in-process (the k6 comparison without
+//	the containers) : one app per layer combination, same data, cold cache at the start
+//	-> go run ./cmd/bench											(SQLite + miniredis, offline)
+//	-> go run ./cmd/bench -vus 200 -duration 30s -layers none,service,handler+repository
+//	-> go run ./cmd/bench -dsn '...' -redis-addr localhost:6379 -json bench.json -csv bench.csv
+//	miniredis is a functional stand-in, not a performance model (one lock, ZSETs sorted on every
+//	read) : compare layers with it, quote numbers from a real redis
+
+var (
+	layers    = flag.String("layers", "none,repository,service,handler,all,leaderboard", "layer combinations to compare, \"+\" joins layers (e.g. repository+handler)")
+	vus       = flag.Int("vus", 50, "virtual users (concurrent clients)")
+	duration  = flag.Duration("duration", 10*time.Second, "measured run per combination")
+	warmup    = flag.Duration("warmup", 0, "unmeasured run before each measurement (0 = measure the cold start too)")
+	pages     = flag.Int("pages", 10, "requests spread over the first n pages of the default listing")
+	dsn       = flag.String("dsn", "", "MariaDB DSN (default: SQLite in a temporary file)")
+	redisAddr = flag.String("redis-addr", "", "redis address (default: in-process miniredis)")
+	jsonOut   = flag.String("json", "", "also write the results to this JSON file")
+	csvOut    = flag.String("csv", "", "also write the results to this CSV file")
+)
+
+type result struct {
+	Layers     string  `json:"layers"`
+	VUs        int     `json:"vus"`
+	Duration   float64 `json:"duration_s"`
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	Throughput float64 `json:"rps"`
+	P50        float64 `json:"p50_ms"`
+	P95        float64 `json:"p95_ms"`
+	P99        float64 `json:"p99_ms"`
+	DBQueries  int64   `json:"db_queries"`
+}
+
+func main() {
+
+	flag.Parse()
+
+	db, cleanup, err := openDatabase()
+	if err != nil {
+		fail(err)
+	}
+	defer cleanup()
+	queries := countQueries(db)
+
+	addr := *redisAddr
+	if addr == "" {
+		mr, err := miniredis.Run()
+		if err != nil {
+			fail(err)
+		}
+		defer mr.Close()
+		addr = mr.Addr()
+	}
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fail(err)
+	}
+	cfg.Redis.Mode = config.RedisStandalone
+	cfg.Redis.Addr = addr
+	cfg.Cache.Warmup.Enabled = false // the cold start is part of the comparison
+	cfg.Inventory.WriteBehind.Enabled = false
+	cfg.RateLimit.Enabled = false
+	redisClient := cfg.Redis.NewClient()
+	defer redisClient.Close()
+
+	results := []result{}
+	for _, combination := range strings.Split(*layers, ",") {
+		cfg.Cache.Layer = strings.ReplaceAll(strings.TrimSpace(combination), "+", ",")
+		r, err := run(cfg, db, redisClient, queries)
+		if err != nil {
+			fail(fmt.Errorf("%v: %w", combination, err))
+		}
+		r.Layers = strings.TrimSpace(combination)
+		results = append(results, r)
+	}
+
+	printTable(os.Stdout, results)
+	if *jsonOut != "" {
+		if err := writeFile(*jsonOut, results, writeJSON); err != nil {
+			fail(err)
+		}
+	}
+	if *csvOut != "" {
+		if err := writeFile(*csvOut, results, writeCSV); err != nil {
+			fail(err)
+		}
+	}
+}
+
+//	run
+
+// run starts the app with cfg on a local port, drives it with the virtual
+// users and stops it. The caches are dropped first so every combination
+// starts cold.
+func run(cfg config.Config, db *gorm.DB, redisClient redis.UniversalClient, queries *int64) (r result, err error) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	if err := resetCaches(ctx, redisClient); err != nil {
+		return r, err
+	}
+	server, err := app.New(ctx, cfg, db, redisClient)
+	if err != nil {
+		return r, err
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return r, err
+	}
+	// fasthttp directly : fiber's Listener would print its banner every run
+	httpServer := &fasthttp.Server{Handler: server.Handler()}
+	go httpServer.Serve(ln)
+	defer httpServer.Shutdown()
+
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{MaxIdleConnsPerHost: *vus, MaxConnsPerHost: *vus},
+	}
+	base := "http://" + ln.Addr().String() + "/products"
+
+	if *warmup > 0 {
+		load(client, base, *warmup)
+	}
+
+	before := atomic.LoadInt64(queries)
+	start := time.Now()
+	latencies, errs := load(client, base, *duration)
+	elapsed := time.Since(start)
+
+	r = result{
+		VUs:        *vus,
+		Duration:   elapsed.Seconds(),
+		Requests:   len(latencies),
+		Errors:     errs,
+		Throughput: float64(len(latencies)) / elapsed.Seconds(),
+		DBQueries:  atomic.LoadInt64(queries) - before,
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.P50 = percentile(latencies, 0.50)
+	r.P95 = percentile(latencies, 0.95)
+	r.P99 = percentile(latencies, 0.99)
+	return r, nil
+}
+
+// load runs the virtual users for d. Each one requests a random page of the
+// first -pages, waits for the answer and goes again.
+func load(client *http.Client, base string, d time.Duration) (latencies []time.Duration, errs int) {
+	deadline := time.Now().Add(d)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	for vu := 0; vu < *vus; vu++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(seed))
+			own := []time.Duration{}
+			failed := 0
+			for time.Now().Before(deadline) {
+				url := base + "?offset=" + strconv.Itoa(random.Intn(*pages)*repositories.DefaultLimit)
+				start := time.Now()
+				if err := get(client, url); err != nil {
+					failed++
+					continue
+				}
+				own = append(own, time.Since(start))
+			}
+			mu.Lock()
+			latencies = append(latencies, own...)
+			errs += failed
+			mu.Unlock()
+		}(int64(vu))
+	}
+	wg.Wait()
+	return latencies, errs
+}
+
+func get(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %v", resp.StatusCode)
+	}
+	return nil
+}
+
+// percentile of sorted latencies, in milliseconds (nearest rank).
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return float64(sorted[i].Microseconds()) / 1000
+}
+
+// resetCaches drops the cached listings and the leaderboard (rebuilt by
+// app.New when missing), without touching anything else in redis.
+func resetCaches(ctx context.Context, redisClient redis.UniversalClient) error {
+	if err := cache.InvalidateCatalog(ctx, redisClient); err != nil {
+		return err
+	}
+	return redisClient.Del(ctx, repositories.LeaderboardKey).Err()
+}
+
+//	database
+
+// openDatabase opens -dsn, or a SQLite file in a temporary directory (WAL, so
+// reads run concurrently like they would on MariaDB). The products are seeded
+// by the repository on the first app.New.
+func openDatabase() (db *gorm.DB, cleanup func(), err error) {
+	config := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+	if *dsn != "" {
+		db, err = gorm.Open(mysql.Open(*dsn), config)
+		return db, func() {}, err
+	}
+
+	dir, err := os.MkdirTemp("", "goredis-bench")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+	path := filepath.Join(dir, "bench.db") + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+	if db, err = gorm.Open(sqlite.Open(path), config); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return db, cleanup, nil
+}
+
+// countQueries counts every statement that reads the database (Find, First,
+// Count, ...), which is what the caches are there to avoid.
+func countQueries(db *gorm.DB) *int64 {
+	count := new(int64)
+	inc := func(*gorm.DB) { atomic.AddInt64(count, 1) }
+	db.Callback().Query().After("gorm:query").Register("bench:count", inc)
+	db.Callback().Row().After("gorm:row").Register("bench:count", inc)
+	return count
+}
+
+//	output
+
+func printTable(w io.Writer, results []result) {
+	out := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(out, "layers\tvus\trequests\terrors\treq/s\tp50 ms\tp95 ms\tp99 ms\tdb queries\tdb/req\t")
+	for _, r := range results {
+		perRequest := 0.0
+		if r.Requests > 0 {
+			perRequest = float64(r.DBQueries) / float64(r.Requests)
+		}
+		fmt.Fprintf(out, "%v\t%v\t%v\t%v\t%.0f\t%.2f\t%.2f\t%.2f\t%v\t%.3f\t\n",
+			r.Layers, r.VUs, r.Requests, r.Errors, r.Throughput, r.P50, r.P95, r.P99, r.DBQueries, perRequest)
+	}
+	out.Flush()
+}
+
+func writeFile(path string, results []result, write func(io.Writer, []result) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, results); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeJSON(w io.Writer, results []result) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+func writeCSV(w io.Writer, results []result) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"layers", "vus", "duration_s", "requests", "errors", "rps", "p50_ms", "p95_ms", "p99_ms", "db_queries"})
+	for _, r := range results {
+		out.Write([]string{
+			r.Layers,
+			strconv.Itoa(r.VUs),
+			strconv.FormatFloat(r.Duration, 'f', 3, 64),
+			strconv.Itoa(r.Requests),
+			strconv.Itoa(r.Errors),
+			strconv.FormatFloat(r.Throughput, 'f', 1, 64),
+			strconv.FormatFloat(r.P50, 'f', 3, 64),
+			strconv.FormatFloat(r.P95, 'f', 3, 64),
+			strconv.FormatFloat(r.P99, 'f', 3, 64),
+			strconv.FormatInt(r.DBQueries, 10),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"goredis/config"
+	"goredis/repositories"
+	"os"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+//	Re-sync the redis leaderboard (ZSET + product hashes) from the products table
+//	-> go run ./cmd/leaderboard
+//	-> go run ./cmd/leaderboard --dsn '...' --redis-addr localhost:6379 (or --redis-mode / --redis-addrs)
+
+func main() {
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fail(err)
+	}
+
+	db, err := gorm.Open(mysql.Open(cfg.DB.DSN), &gorm.Config{})
+	if err != nil {
+		fail(err)
+	}
+
+	//	a rebuild is a batch job : no fail-fast timeouts here
+	cfg.Redis.ReadTimeout = 5 * time.Second
+	cfg.Redis.WriteTimeout = 5 * time.Second
+	cfg.Redis.MaxRetries = 3
+	redisClient := cfg.Redis.NewClient()
+	defer redisClient.Close()
+
+	start := time.Now()
+	count, err := repositories.RebuildLeaderboard(context.Background(), db, redisClient)
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("leaderboard: ranked %v products in %v\n", count, time.Since(start).Round(time.Millisecond))
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,426 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"goredis/cache"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+//	config (config.yml < environment < flags)
+
+type Config struct {
+	App       AppConfig       `mapstructure:"app"`
+	DB        DBConfig        `mapstructure:"db"`
+	Redis     RedisConfig     `mapstructure:"redis"`
+	Cache     CacheConfig     `mapstructure:"cache"`
+	Inventory InventoryConfig `mapstructure:"inventory"`
+	RateLimit RateLimitConfig `mapstructure:"rateLimit"`
+	Admin     AdminConfig     `mapstructure:"admin"`
+	Health    HealthConfig    `mapstructure:"health"`
+	Tracing   TracingConfig   `mapstructure:"tracing"`
+}
+
+type AppConfig struct {
+	Port int `mapstructure:"port"`
+	// RequestTimeout is the deadline of a request, database and Redis calls
+	// included; past it the request is answered 504 (0 disables it).
+	RequestTimeout time.Duration `mapstructure:"requestTimeout"`
+}
+
+type DBConfig struct {
+	DSN string `mapstructure:"dsn"`
+}
+
+// RedisConfig picks the topology with Mode: standalone uses Addr, sentinel
+// asks the Addrs sentinels for MasterName, cluster seeds from Addrs.
+type RedisConfig struct {
+	Mode         string        `mapstructure:"mode"`
+	Addr         string        `mapstructure:"addr"`
+	Addrs        []string      `mapstructure:"addrs"`
+	MasterName   string        `mapstructure:"masterName"`
+	Username     string        `mapstructure:"username"`
+	Password     string        `mapstructure:"password"`
+	DB           int           `mapstructure:"db"`
+	DialTimeout  time.Duration `mapstructure:"dialTimeout"`
+	ReadTimeout  time.Duration `mapstructure:"readTimeout"`
+	WriteTimeout time.Duration `mapstructure:"writeTimeout"`
+	// PoolSize is the connections per node (0 = 10 per CPU), MinIdleConns
+	// the ones kept open while idle.
+	PoolSize     int `mapstructure:"poolSize"`
+	MinIdleConns int `mapstructure:"minIdleConns"`
+	// MaxRetries is -1 (no retries) by default, a retry would only double
+	// the latency of a cache that is already slow.
+	MaxRetries int `mapstructure:"maxRetries"`
+	Breaker    struct {
+		Failures    int           `mapstructure:"failures"`
+		OpenTimeout time.Duration `mapstructure:"openTimeout"`
+	} `mapstructure:"breaker"`
+}
+
+type InventoryConfig struct {
+	// ReservationTTL is how long reserved stock is held before it goes back
+	// to the available stock.
+	ReservationTTL time.Duration     `mapstructure:"reservationTTL"`
+	WriteBehind    WriteBehindConfig `mapstructure:"writeBehind"`
+}
+
+// WriteBehindConfig drives POST /products/:id/stock: changes are queued in
+// a Redis Stream and flushed to the database by a background writer.
+type WriteBehindConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	Group         string        `mapstructure:"group"`
+	BatchSize     int           `mapstructure:"batchSize"`
+	FlushInterval time.Duration `mapstructure:"flushInterval"`
+	Retries       int           `mapstructure:"retries"`
+	ClaimIdle     time.Duration `mapstructure:"claimIdle"`
+	DrainTimeout  time.Duration `mapstructure:"drainTimeout"`
+}
+
+// RateLimitConfig lists per route limits; the first rule matching a request
+// applies. Key is ip, apikey (APIKeyHeader, one of APIKeys) or jwt (HS256,
+// JWTSecret).
+type RateLimitConfig struct {
+	Enabled      bool            `mapstructure:"enabled"`
+	FailClosed   bool            `mapstructure:"failClosed"`
+	APIKeyHeader string          `mapstructure:"apiKeyHeader"`
+	APIKeys      []string        `mapstructure:"apiKeys"`
+	JWTSecret    string          `mapstructure:"jwtSecret"`
+	Rules        []RateLimitRule `mapstructure:"rules"`
+}
+
+type RateLimitRule struct {
+	Name   string        `mapstructure:"name"`
+	Method string        `mapstructure:"method"`
+	Path   string        `mapstructure:"path"`
+	Key    string        `mapstructure:"key"`
+	Limit  int           `mapstructure:"limit"`
+	Window time.Duration `mapstructure:"window"`
+	Burst  int           `mapstructure:"burst"`
+}
+
+// Validate checks that every rule has a limit and a window of at least 1ms:
+// the buckets refill by the millisecond.
+func (c RateLimitConfig) Validate() error {
+	for i, rule := range c.Rules {
+		if rule.Limit <= 0 || rule.Window < time.Millisecond {
+			return fmt.Errorf("config: rateLimit.rules[%d] (%v) needs a limit and a window of at least 1ms", i, rule.Name)
+		}
+	}
+	return nil
+}
+
+// AdminConfig guards the /admin API (cache inspection and flushes), which
+// is off while Token is empty. Flushes are kept in a stream of AuditLength
+// entries.
+type AdminConfig struct {
+	Token       string        `mapstructure:"token"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+	AuditLength int64         `mapstructure:"auditLength"`
+}
+
+// HealthConfig drives GET /readyz: each dependency is pinged within
+// Timeout. With RedisOptional a Redis outage is reported but the app stays
+// ready (the caches fall back to the database).
+type HealthConfig struct {
+	Timeout       time.Duration `mapstructure:"timeout"`
+	RedisOptional bool          `mapstructure:"redisOptional"`
+}
+
+// TracingConfig picks the span exporter: none, otlp (OTLP/HTTP to
+// Endpoint), stdout or memory. SampleRatio applies to the traces started
+// here; a request with a sampled traceparent is always recorded.
+type TracingConfig struct {
+	Exporter    string  `mapstructure:"exporter"`
+	Endpoint    string  `mapstructure:"endpoint"`
+	Insecure    bool    `mapstructure:"insecure"`
+	SampleRatio float64 `mapstructure:"sampleRatio"`
+	ServiceName string  `mapstructure:"serviceName"`
+}
+
+type CacheConfig struct {
+	// Layer is none, repository, service, handler, all, or a comma list
+	// such as "repository,handler". "leaderboard" (not part of all) puts the
+	// ZSET leaderboard under the repository.
+	Layer        string        `mapstructure:"layer"`
+	TTL          time.Duration `mapstructure:"ttl"`
+	Stale        time.Duration `mapstructure:"stale"`
+	EarlyRefresh float64       `mapstructure:"earlyRefresh"`
+	// Codec is json, msgpack, gob or protobuf; payloads of at least
+	// CompressThreshold bytes are compressed with Compression (none, snappy,
+	// zstd). Entries written with an older setting are still read.
+	Codec             string `mapstructure:"codec"`
+	Compression       string `mapstructure:"compression"`
+	CompressThreshold int    `mapstructure:"compressThreshold"`
+	L1                struct {
+		Size int           `mapstructure:"size"`
+		TTL  time.Duration `mapstructure:"ttl"`
+	} `mapstructure:"l1"`
+	Warmup WarmupConfig `mapstructure:"warmup"`
+	// MissingTTL is how long GET /products/:id remembers an unknown id.
+	MissingTTL time.Duration `mapstructure:"missingTTL"`
+	Bloom      BloomConfig   `mapstructure:"bloom"`
+	// TagPruneInterval is how often the keys that expired are removed from
+	// the tag sets (0 = only when a tag is invalidated or its set expires).
+	TagPruneInterval time.Duration `mapstructure:"tagPruneInterval"`
+}
+
+// BloomConfig sizes the Bloom filter of product ids that rejects unknown
+// ids before the database (with any cache layer on). Capacity is the number
+// of products it is built for, past it the false positive rate goes up. It
+// is rebuilt from the table at startup and every RebuildInterval (0 = only
+// at startup), which brings back the ids it missed.
+type BloomConfig struct {
+	Enabled           bool          `mapstructure:"enabled"`
+	Capacity          int           `mapstructure:"capacity"`
+	FalsePositiveRate float64       `mapstructure:"falsePositiveRate"`
+	RebuildInterval   time.Duration `mapstructure:"rebuildInterval"`
+}
+
+// WarmupConfig lists the listings loaded into every cache layer at startup:
+// the first Pages pages of the default listing and Queries. With a
+// RefreshInterval (below cache.ttl) they are reloaded before they expire.
+type WarmupConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	Pages           int           `mapstructure:"pages"`
+	Queries         []WarmupQuery `mapstructure:"queries"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+	RefreshInterval time.Duration `mapstructure:"refreshInterval"`
+}
+
+// WarmupQuery takes the parameters of GET /products; a category makes it
+// GET /categories/:id/products.
+type WarmupQuery struct {
+	Limit       int    `mapstructure:"limit"`
+	Offset      int    `mapstructure:"offset"`
+	Sort        string `mapstructure:"sort"`
+	Order       string `mapstructure:"order"`
+	Name        string `mapstructure:"name"`
+	MinQuantity *int   `mapstructure:"minQuantity"`
+	MaxQuantity *int   `mapstructure:"maxQuantity"`
+	Category    int    `mapstructure:"category"`
+}
+
+const (
+	RedisStandalone = "standalone"
+	RedisSentinel   = "sentinel"
+	RedisCluster    = "cluster"
+)
+
+// Validate checks that the mode has the addresses it needs.
+func (c RedisConfig) Validate() error {
+	switch c.Mode {
+	case RedisStandalone:
+		if c.Addr == "" {
+			return errors.New("config: redis.addr is required in standalone mode")
+		}
+	case RedisSentinel:
+		if c.MasterName == "" || len(c.Addrs) == 0 {
+			return errors.New("config: redis.masterName and redis.addrs (sentinels) are required in sentinel mode")
+		}
+	case RedisCluster:
+		if len(c.Addrs) == 0 {
+			return errors.New("config: redis.addrs (seed nodes) are required in cluster mode")
+		}
+	default:
+		return fmt.Errorf("config: unknown redis mode %q (standalone|sentinel|cluster)", c.Mode)
+	}
+	return nil
+}
+
+const (
+	LayerRepository = "repository"
+	LayerService    = "service"
+	LayerHandler    = "handler"
+	// LayerLeaderboard is not a cache but a Redis copy of the ranking.
+	LayerLeaderboard = "leaderboard"
+)
+
+// Layers returns which adapters get the redis decorator.
+func (c CacheConfig) Layers() (map[string]bool, error) {
+	layers := map[string]bool{}
+	for _, layer := range strings.Split(c.Layer, ",") {
+		switch layer = strings.TrimSpace(strings.ToLower(layer)); layer {
+		case "none", "":
+		case "all":
+			layers[LayerRepository] = true
+			layers[LayerService] = true
+			layers[LayerHandler] = true
+		case LayerRepository, LayerService, LayerHandler, LayerLeaderboard:
+			layers[layer] = true
+		default:
+			return nil, fmt.Errorf("config: unknown cache layer %q (none|repository|service|handler|leaderboard|all)", layer)
+		}
+	}
+	return layers, nil
+}
+
+// Format returns the codec settings in the form the cache package uses.
+func (c CacheConfig) Format() (format cache.Format, err error) {
+	if format.Codec, err = cache.ParseCodec(c.Codec); err != nil {
+		return format, err
+	}
+	if format.Compression, err = cache.ParseCompression(c.Compression); err != nil {
+		return format, err
+	}
+	format.Threshold = c.CompressThreshold
+	return format, nil
+}
+
+func defaults(v *viper.Viper) {
+	v.SetDefault("app.port", 8000)
+	v.SetDefault("app.requestTimeout", "3s")
+	v.SetDefault("db.dsn", "root:pass@tcp(127.0.0.1:3306)/testdb2?parseTime=True")
+	v.SetDefault("redis.mode", RedisStandalone)
+	v.SetDefault("redis.addr", "localhost:6379")
+	v.SetDefault("redis.addrs", []string{})
+	v.SetDefault("redis.masterName", "")
+	v.SetDefault("redis.username", "")
+	v.SetDefault("redis.password", "")
+	v.SetDefault("redis.db", 0)
+	v.SetDefault("redis.dialTimeout", "200ms")
+	v.SetDefault("redis.readTimeout", "100ms")
+	v.SetDefault("redis.writeTimeout", "100ms")
+	v.SetDefault("redis.poolSize", 0)
+	v.SetDefault("redis.minIdleConns", 0)
+	v.SetDefault("redis.maxRetries", -1)
+	v.SetDefault("redis.breaker.failures", 5)
+	v.SetDefault("redis.breaker.openTimeout", "5s")
+	v.SetDefault("cache.layer", LayerService)
+	v.SetDefault("cache.ttl", "10s")
+	v.SetDefault("cache.stale", "20s")
+	v.SetDefault("cache.earlyRefresh", 1)
+	v.SetDefault("cache.codec", "json")
+	v.SetDefault("cache.compression", "none")
+	v.SetDefault("cache.compressThreshold", 1024)
+	v.SetDefault("cache.l1.size", 1000)
+	v.SetDefault("cache.l1.ttl", "2s")
+	v.SetDefault("cache.warmup.enabled", true)
+	v.SetDefault("cache.warmup.pages", 3)
+	v.SetDefault("cache.warmup.timeout", "30s")
+	v.SetDefault("cache.missingTTL", "5s")
+	v.SetDefault("cache.bloom.enabled", true)
+	v.SetDefault("cache.bloom.capacity", 1000000)
+	v.SetDefault("cache.bloom.falsePositiveRate", 0.01)
+	v.SetDefault("cache.bloom.rebuildInterval", "1h")
+	v.SetDefault("cache.tagPruneInterval", "5m")
+	v.SetDefault("inventory.reservationTTL", "5m")
+	v.SetDefault("inventory.writeBehind.enabled", true)
+	v.SetDefault("inventory.writeBehind.group", "writers")
+	v.SetDefault("inventory.writeBehind.batchSize", 500)
+	v.SetDefault("inventory.writeBehind.flushInterval", "1s")
+	v.SetDefault("inventory.writeBehind.retries", 3)
+	v.SetDefault("inventory.writeBehind.claimIdle", "30s")
+	v.SetDefault("inventory.writeBehind.drainTimeout", "10s")
+	v.SetDefault("rateLimit.enabled", false)
+	v.SetDefault("rateLimit.apiKeyHeader", "X-API-Key")
+	v.SetDefault("rateLimit.apiKeys", []string{})
+	v.SetDefault("rateLimit.jwtSecret", "")
+	v.SetDefault("admin.token", "")
+	v.SetDefault("admin.timeout", "30s")
+	v.SetDefault("admin.auditLength", 10000)
+	v.SetDefault("health.timeout", "1s")
+	v.SetDefault("health.redisOptional", false)
+	v.SetDefault("tracing.exporter", "none")
+	v.SetDefault("tracing.endpoint", "localhost:4318")
+	v.SetDefault("tracing.insecure", true)
+	v.SetDefault("tracing.sampleRatio", 1)
+	v.SetDefault("tracing.serviceName", "goredis")
+}
+
+// bindEnv binds the environment variable of every key of t, so keys without
+// a default (rateLimit.failClosed, ...) are read from the environment too.
+func bindEnv(v *viper.Viper, t reflect.Type, prefix string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := prefix + field.Tag.Get("mapstructure")
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)) {
+			bindEnv(v, field.Type, key+".")
+			continue
+		}
+		// the error is only for an empty key
+		_ = v.BindEnv(key)
+	}
+}
+
+// Load reads config.yml (optional, from --config or the working directory),
+// then environment variables (CACHE_LAYER, DB_DSN, ...), then flags.
+func Load(args []string) (cfg Config, err error) {
+	flags := pflag.NewFlagSet("goredis", pflag.ContinueOnError)
+	configFile := flags.String("config", "", "path to config.yml")
+	flags.String("cache-layer", "", "none|repository|service|handler|leaderboard|all (or a comma list)")
+	flags.String("dsn", "", "MariaDB DSN")
+	flags.String("redis-mode", "", "standalone|sentinel|cluster")
+	flags.String("redis-addr", "", "redis address (standalone)")
+	flags.StringSlice("redis-addrs", nil, "sentinel or cluster seed addresses")
+	flags.Int("port", 0, "listen port")
+	flags.Duration("request-timeout", 0, "deadline of a request (504 past it)")
+	flags.Duration("cache-ttl", 0, "fresh TTL of cached entries")
+	flags.Duration("cache-stale", 0, "how long entries are served stale after the TTL")
+	flags.String("tracing-exporter", "", "none|otlp|stdout|memory")
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	v := viper.New()
+	defaults(v)
+
+	if *configFile != "" {
+		v.SetConfigFile(*configFile)
+	} else {
+		v.SetConfigName("config")
+		v.SetConfigType("yml")
+		v.AddConfigPath(".")
+	}
+	if err := v.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) || *configFile != "" {
+			return cfg, err
+		}
+	}
+
+	v.AutomaticEnv()
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	// AutomaticEnv only looks up the keys viper already knows (a default or
+	// config.yml), bind the others too
+	bindEnv(v, reflect.TypeOf(cfg), "")
+
+	for key, flag := range map[string]string{
+		"cache.layer":        "cache-layer",
+		"db.dsn":             "dsn",
+		"redis.mode":         "redis-mode",
+		"redis.addr":         "redis-addr",
+		"redis.addrs":        "redis-addrs",
+		"app.port":           "port",
+		"app.requestTimeout": "request-timeout",
+		"cache.ttl":          "cache-ttl",
+		"cache.stale":        "cache-stale",
+		"tracing.exporter":   "tracing-exporter",
+	} {
+		if err := v.BindPFlag(key, flags.Lookup(flag)); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := v.Unmarshal(&cfg); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Redis.Validate(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.RateLimit.Validate(); err != nil {
+		return cfg, err
+	}
+	if _, err := cfg.Cache.Layers(); err != nil {
+		return cfg, err
+	}
+	if _, err := cfg.Cache.Format(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		get  func(cfg Config) interface{}
+		want interface{}
+	}{
+		{name: "default", get: func(cfg Config) interface{} { return cfg.Redis.Addr }, want: "localhost:6379"},
+		{name: "key with a default", env: map[string]string{"REDIS_ADDR": "redis:6380"}, get: func(cfg Config) interface{} { return cfg.Redis.Addr }, want: "redis:6380"},
+		{name: "master name", env: map[string]string{"REDIS_MODE": "sentinel", "REDIS_ADDRS": "s1:26379", "REDIS_MASTERNAME": "mymaster"}, get: func(cfg Config) interface{} { return cfg.Redis.MasterName }, want: "mymaster"},
+		{name: "addrs", env: map[string]string{"REDIS_MODE": "cluster", "REDIS_ADDRS": "n1:6379,n2:6379"}, get: func(cfg Config) interface{} { return cfg.Redis.Addrs }, want: []string{"n1:6379", "n2:6379"}},
+		{name: "username", env: map[string]string{"REDIS_USERNAME": "app"}, get: func(cfg Config) interface{} { return cfg.Redis.Username }, want: "app"},
+		{name: "password", env: map[string]string{"REDIS_PASSWORD": "secret"}, get: func(cfg Config) interface{} { return cfg.Redis.Password }, want: "secret"},
+		{name: "db", env: map[string]string{"REDIS_DB": "3"}, get: func(cfg Config) interface{} { return cfg.Redis.DB }, want: 3},
+		{name: "pool size", env: map[string]string{"REDIS_POOLSIZE": "40"}, get: func(cfg Config) interface{} { return cfg.Redis.PoolSize }, want: 40},
+		{name: "nested key", env: map[string]string{"REDIS_BREAKER_OPENTIMEOUT": "1s"}, get: func(cfg Config) interface{} { return cfg.Redis.Breaker.OpenTimeout }, want: time.Second},
+		{name: "key without a default", env: map[string]string{"RATELIMIT_FAILCLOSED": "true"}, get: func(cfg Config) interface{} { return cfg.RateLimit.FailClosed }, want: true},
+		{name: "flag over env", env: map[string]string{"REDIS_ADDR": "redis:6380"}, args: []string{"--redis-addr=flag:6381"}, get: func(cfg Config) interface{} { return cfg.Redis.Addr }, want: "flag:6381"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cfg, err := Load(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.get(cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRateLimitWindow(t *testing.T) {
+	tests := []struct {
+		window  string
+		wantErr bool
+	}{
+		{window: "1s"},
+		{window: "1ms"},
+		{window: "500us", wantErr: true},
+		{window: "0s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.window, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config.yml")
+			yml := "rateLimit:\n  rules:\n    - name: writes\n      limit: 10\n      window: " + tt.window + "\n"
+			if err := os.WriteFile(file, []byte(yml), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load([]string{"--config", file}); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
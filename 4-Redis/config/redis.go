@@ -0,0 +1,30 @@
+package config
+
+import "github.com/go-redis/redis/v9"
+
+// NewClient connects to the topology of c. The adapters only see the
+// redis.UniversalClient, so the same code runs on each of them.
+func (c RedisConfig) NewClient() redis.UniversalClient {
+	options := &redis.UniversalOptions{
+		Addrs:        c.Addrs,
+		MasterName:   c.MasterName,
+		Username:     c.Username,
+		Password:     c.Password,
+		DB:           c.DB,
+		DialTimeout:  c.DialTimeout,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+		PoolSize:     c.PoolSize,
+		MinIdleConns: c.MinIdleConns,
+		MaxRetries:   c.MaxRetries,
+	}
+
+	switch c.Mode {
+	case RedisSentinel:
+		return redis.NewFailoverClient(options.Failover())
+	case RedisCluster:
+		return redis.NewClusterClient(options.Cluster())
+	}
+	options.Addrs = []string{c.Addr}
+	return redis.NewClient(options.Simple())
+}
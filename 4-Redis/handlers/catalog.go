@@ -4,4 +4,11 @@ import "github.com/gofiber/fiber/v2"
 
 type CatalogHandler interface {
 	GetProducts(c *fiber.Ctx) error
+	GetCategoryProducts(c *fiber.Ctx) error
+	GetProduct(c *fiber.Ctx) error
+	GetProductRank(c *fiber.Ctx) error
+	CreateProduct(c *fiber.Ctx) error
+	UpdateProduct(c *fiber.Ctx) error
+	PatchProduct(c *fiber.Ctx) error
+	DeleteProduct(c *fiber.Ctx) error
 }
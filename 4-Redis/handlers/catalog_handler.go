@@ -1,13 +1,20 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"goredis/services"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 type catalogHandler struct {
 	catalogSrv services.CatalogService
+	// written, when set, is called after each successful write with the id
+	// of the product and the fields written (nil when it was created or
+	// deleted)
+	written func(id int, fields []string)
 }
 
 func NewCatalogHandler(catalogSrv services.CatalogService) CatalogHandler {
@@ -16,15 +23,218 @@ func NewCatalogHandler(catalogSrv services.CatalogService) CatalogHandler {
 
 func (h catalogHandler) GetProducts(c *fiber.Ctx) error {
 
-	products, err := h.catalogSrv.GetProducts()
+	query, err := productQuery(c)
 	if err != nil {
 		return err
 	}
 
+	page, err := h.catalogSrv.GetProducts(c.UserContext(), query)
+	if err != nil {
+		return serviceError(err)
+	}
+
 	response := fiber.Map{
-		"Status":   "ok",
-		"products": products,
+		"status":   "ok",
+		"products": page.Products,
+		"paging":   page.Paging,
 	}
 
 	return c.JSON(response)
 }
+
+func (h catalogHandler) GetCategoryProducts(c *fiber.Ctx) error {
+	query, err := categoryQuery(c)
+	if err != nil {
+		return err
+	}
+
+	page, err := h.catalogSrv.GetProducts(c.UserContext(), query)
+	if err != nil {
+		return serviceError(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"status":   "ok",
+		"products": page.Products,
+		"paging":   page.Paging,
+	})
+}
+
+func (h catalogHandler) GetProduct(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	product, err := h.catalogSrv.GetProduct(c.UserContext(), id)
+	if err != nil {
+		return serviceError(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "ok",
+		"product": product,
+	})
+}
+
+func (h catalogHandler) GetProductRank(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	rank, err := h.catalogSrv.GetProductRank(c.UserContext(), id)
+	if err != nil {
+		return serviceError(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "ok",
+		"id":     rank.ID,
+		"rank":   rank.Rank,
+	})
+}
+
+func (h catalogHandler) CreateProduct(c *fiber.Ctx) error {
+	input := services.ProductInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.ErrUnprocessableEntity
+	}
+
+	product, err := h.catalogSrv.CreateProduct(c.UserContext(), input)
+	if err != nil {
+		return serviceError(err)
+	}
+	h.afterWrite(product.ID, nil)
+
+	c.Status(fiber.StatusCreated)
+	return c.JSON(fiber.Map{
+		"status":  "ok",
+		"product": product,
+	})
+}
+
+func (h catalogHandler) UpdateProduct(c *fiber.Ctx) error {
+	return h.writeProduct(c, h.catalogSrv.UpdateProduct, true)
+}
+
+func (h catalogHandler) PatchProduct(c *fiber.Ctx) error {
+	return h.writeProduct(c, h.catalogSrv.PatchProduct, false)
+}
+
+func (h catalogHandler) DeleteProduct(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	if err := h.catalogSrv.DeleteProduct(c.UserContext(), id); err != nil {
+		return serviceError(err)
+	}
+	h.afterWrite(id, nil)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+//	helper
+
+// productQuery reads ?limit=&offset=&sort=&order=&name=&min_quantity=&max_quantity=
+func productQuery(c *fiber.Ctx) (query services.ProductQuery, err error) {
+	intParam := func(name string) (*int, error) {
+		value := c.Query(name)
+		if value == "" {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "invalid "+name)
+		}
+		return &n, nil
+	}
+
+	limit, err := intParam("limit")
+	if err != nil {
+		return query, err
+	}
+	offset, err := intParam("offset")
+	if err != nil {
+		return query, err
+	}
+	if limit != nil {
+		query.Limit = *limit
+	}
+	if offset != nil {
+		query.Offset = *offset
+	}
+	if query.MinQuantity, err = intParam("min_quantity"); err != nil {
+		return query, err
+	}
+	if query.MaxQuantity, err = intParam("max_quantity"); err != nil {
+		return query, err
+	}
+	query.Sort = c.Query("sort")
+	query.Order = c.Query("order")
+	query.NamePrefix = c.Query("name")
+
+	return query.Normalize(), nil
+}
+
+// categoryQuery is the productQuery of the category of the route.
+func categoryQuery(c *fiber.Ctx) (services.ProductQuery, error) {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return services.ProductQuery{}, fiber.ErrBadRequest
+	}
+	query, err := productQuery(c)
+	if err != nil {
+		return query, err
+	}
+	query.CategoryID = id
+	return query.Normalize(), nil
+}
+
+// writeProduct parses the body and calls write; with replace (PUT) every
+// field is written, the ones left out of the body reset.
+func (h catalogHandler) writeProduct(c *fiber.Ctx, write func(context.Context, int, services.ProductInput) (services.Product, error), replace bool) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	input := services.ProductInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.ErrUnprocessableEntity
+	}
+
+	product, err := write(c.UserContext(), id, input)
+	if err != nil {
+		return serviceError(err)
+	}
+	if replace {
+		input = input.Replace()
+	}
+	h.afterWrite(product.ID, input.Fields())
+
+	return c.JSON(fiber.Map{
+		"status":  "ok",
+		"product": product,
+	})
+}
+
+func (h catalogHandler) afterWrite(id int, fields []string) {
+	if h.written != nil {
+		h.written(id, fields)
+	}
+}
+
+func serviceError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrProductNotFound), errors.Is(err, services.ErrCategoryNotFound):
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrInvalidProduct):
+		return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, services.ErrDuplicateSKU):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	}
+	return err
+}
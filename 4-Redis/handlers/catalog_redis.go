@@ -3,9 +3,8 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"goredis/cache"
 	"goredis/services"
-	"time"
 
 	"github.com/go-redis/redis/v9"
 	"github.com/gofiber/fiber/v2"
@@ -13,40 +12,173 @@ import (
 
 type catalogHandlerRedis struct {
 	catalogSrv  services.CatalogService
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
+	settings    cache.Settings
+	responses   cache.Aside[services.ProductQuery, cachedResponse]
+	product     cache.Aside[int, cachedResponse]
 }
 
-func NewCatalogHanlderRedis(catalogSrv services.CatalogService, redisClient *redis.Client) CatalogHandler {
-	return catalogHandlerRedis{catalogSrv, redisClient}
+func NewCatalogHanlderRedis(catalogSrv services.CatalogService, redisClient redis.UniversalClient, settings cache.Settings) CatalogHandler {
+	return catalogHandlerRedis{
+		catalogSrv:  catalogSrv,
+		redisClient: redisClient,
+		settings:    settings,
+		responses: cache.NewAside(redisClient, cache.Options[services.ProductQuery, cachedResponse]{
+			Name:   "handler",
+			Family: cache.HandlerGetProducts,
+			Key: func(query services.ProductQuery) string {
+				return cache.Key(cache.HandlerGetProducts, query.CacheKey())
+			},
+			TTL:          settings.TTL,
+			Stale:        settings.Stale,
+			EarlyRefresh: settings.EarlyRefresh,
+			Local:        settings.Local,
+			Codec:        cache.Frame[cachedResponse](responseCodec{}, settings.Format),
+			Tags: func(query services.ProductQuery, response cachedResponse) []string {
+				return append(query.CacheTags(), cache.ProductTags(response.products...)...)
+			},
+		}),
+		product: cache.NewAside(redisClient, cache.Options[int, cachedResponse]{
+			Name:   "handler",
+			Family: cache.HandlerGetProduct,
+			Key: func(id int) string {
+				return cache.ProductKey(cache.HandlerGetProduct, id)
+			},
+			TTL:          settings.TTL,
+			Stale:        settings.Stale,
+			EarlyRefresh: settings.EarlyRefresh,
+			Local:        settings.Local,
+			Codec:        cache.Frame[cachedResponse](responseCodec{}, settings.Format),
+			Missing:      services.ErrProductNotFound,
+			MissingTTL:   settings.MissingTTL,
+			Tags: func(id int, _ cachedResponse) []string {
+				return []string{cache.ProductTag(id)}
+			},
+		}),
+	}
 }
 
 func (h catalogHandlerRedis) GetProducts(c *fiber.Ctx) error {
 
-	key := "handler::GetProducts"
+	query, err := productQuery(c)
+	if err != nil {
+		return err
+	}
 
-	// Redis GET
-	if responseJson, err := h.redisClient.Get(context.Background(), key).Result(); err == nil {
-		fmt.Println("redis")
-		c.Set("Content-Type", "application/json")
-		return c.SendString(responseJson)
+	response, err := h.responses.Get(c.UserContext(), query, h.renderProducts)
+	if err != nil {
+		return serviceError(err)
 	}
 
-	// Service
-	products, err := h.catalogSrv.GetProducts()
+	// ETag / Last-Modified are cached with the body, a 304 costs one redis GET
+	return response.send(c, h.settings.TTL, h.settings.Stale)
+}
+
+// GetCategoryProducts shares the listing entries: their keys and tags are
+// scoped by category.
+func (h catalogHandlerRedis) GetCategoryProducts(c *fiber.Ctx) error {
+	query, err := categoryQuery(c)
 	if err != nil {
 		return err
 	}
 
+	response, err := h.responses.Get(c.UserContext(), query, h.renderProducts)
+	if err != nil {
+		return serviceError(err)
+	}
+	return response.send(c, h.settings.TTL, h.settings.Stale)
+}
+
+// WarmProducts renders a listing into the cache ahead of the requests.
+func (h catalogHandlerRedis) WarmProducts(ctx context.Context, query services.ProductQuery) error {
+	return h.responses.Warm(ctx, query.Normalize(), h.renderProducts)
+}
+
+func (h catalogHandlerRedis) renderProducts(ctx context.Context, query services.ProductQuery) (cachedResponse, error) {
+	page, err := h.catalogSrv.GetProducts(ctx, query)
+	if err != nil {
+		return cachedResponse{}, err
+	}
+
 	response := fiber.Map{
 		"status":   "ok",
-		"products": products,
+		"products": page.Products,
+		"paging":   page.Paging,
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		return cachedResponse{}, err
+	}
+	cached := newCachedResponse(body)
+	for _, product := range page.Products {
+		cached.products = append(cached.products, product.ID)
+	}
+	return cached, nil
+}
+
+func (h catalogHandlerRedis) GetProduct(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
 	}
 
-	// Redis SET
-	if data, err := json.Marshal(response); err == nil {
-		h.redisClient.Set(context.Background(), key, string(data), time.Second*10)
+	response, err := h.product.Get(c.UserContext(), id, h.renderProduct)
+	if err != nil {
+		return serviceError(err)
+	}
+	return response.send(c, h.settings.TTL, h.settings.Stale)
+}
+
+func (h catalogHandlerRedis) renderProduct(ctx context.Context, id int) (cachedResponse, error) {
+	product, err := h.catalogSrv.GetProduct(ctx, id)
+	if err != nil {
+		return cachedResponse{}, err
+	}
+
+	body, err := json.Marshal(fiber.Map{
+		"status":  "ok",
+		"product": product,
+	})
+	if err != nil {
+		return cachedResponse{}, err
 	}
+	return newCachedResponse(body), nil
+}
+
+func (h catalogHandlerRedis) GetProductRank(c *fiber.Ctx) error {
+	return catalogHandler{catalogSrv: h.catalogSrv}.GetProductRank(c)
+}
+
+//	write (service then invalidate)
 
-	fmt.Println("database")
-	return c.JSON(response)
+func (h catalogHandlerRedis) CreateProduct(c *fiber.Ctx) error {
+	return h.writer().CreateProduct(c)
+}
+
+func (h catalogHandlerRedis) UpdateProduct(c *fiber.Ctx) error {
+	return h.writer().UpdateProduct(c)
+}
+
+func (h catalogHandlerRedis) PatchProduct(c *fiber.Ctx) error {
+	return h.writer().PatchProduct(c)
+}
+
+func (h catalogHandlerRedis) DeleteProduct(c *fiber.Ctx) error {
+	return h.writer().DeleteProduct(c)
+}
+
+// writer is the plain handler, invalidating the entries of the product
+// written once the service returns it.
+func (h catalogHandlerRedis) writer() catalogHandler {
+	return catalogHandler{catalogSrv: h.catalogSrv, written: h.invalidate}
+}
+
+// invalidate drops the entries of a product. An update only drops the
+// listings reading the fields written; a create or a delete drops them all.
+func (h catalogHandlerRedis) invalidate(id int, fields []string) {
+	if fields == nil {
+		cache.InvalidateProducts(context.Background(), h.redisClient, id)
+		return
+	}
+	cache.InvalidateProductFields(context.Background(), h.redisClient, fields, id)
 }
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+//	cached response (body + validators)
+
+type cachedResponse struct {
+	Body         []byte
+	ETag         string
+	LastModified time.Time
+	// products are the ids of a rendered page, for its cache tags (not
+	// stored, the tags are set when the page is)
+	products []int
+}
+
+func newCachedResponse(body []byte) cachedResponse {
+	sum := sha256.Sum256(body)
+	return cachedResponse{
+		Body:         body,
+		ETag:         `"` + hex.EncodeToString(sum[:16]) + `"`,
+		LastModified: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// MarshalJSON shows the body as JSON rather than base64 (admin API).
+func (r cachedResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ETag         string          `json:"etag"`
+		LastModified time.Time       `json:"last_modified"`
+		Body         json.RawMessage `json:"body"`
+	}{r.ETag, r.LastModified, r.Body})
+}
+
+// send writes the validators and Cache-Control, then either 304 or the body.
+func (r cachedResponse) send(c *fiber.Ctx, maxAge time.Duration, stale time.Duration) error {
+	c.Set(fiber.HeaderETag, r.ETag)
+	c.Set(fiber.HeaderLastModified, r.LastModified.Format(http.TimeFormat))
+	cacheControl := fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+	if stale > 0 {
+		cacheControl += fmt.Sprintf(", stale-while-revalidate=%d", int(stale.Seconds()))
+	}
+	c.Set(fiber.HeaderCacheControl, cacheControl)
+
+	if r.notModified(c) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(r.Body)
+}
+
+// notModified follows RFC 9110 13.2.2: If-None-Match wins when present,
+// If-Modified-Since is only looked at without it.
+func (r cachedResponse) notModified(c *fiber.Ctx) bool {
+	if noneMatch := c.Get(fiber.HeaderIfNoneMatch); noneMatch != "" {
+		for _, tag := range strings.Split(noneMatch, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == r.ETag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if modifiedSince := c.Get(fiber.HeaderIfModifiedSince); modifiedSince != "" {
+		since, err := http.ParseTime(modifiedSince)
+		return err == nil && !r.LastModified.After(since)
+	}
+	return false
+}
+
+//	codec: "<etag>\n<last modified unix>\n<body>", so the body is not base64'd
+
+type responseCodec struct{}
+
+var errResponseFormat = errors.New("handlers: malformed cached response")
+
+func (responseCodec) Marshal(r cachedResponse) ([]byte, error) {
+	head := r.ETag + "\n" + strconv.FormatInt(r.LastModified.Unix(), 10) + "\n"
+	return append([]byte(head), r.Body...), nil
+}
+
+func (responseCodec) Unmarshal(data []byte) (r cachedResponse, err error) {
+	parts := bytes.SplitN(data, []byte("\n"), 3)
+	if len(parts) != 3 {
+		return r, errResponseFormat
+	}
+	unix, err := strconv.ParseInt(string(parts[1]), 10, 64)
+	if err != nil {
+		return r, errResponseFormat
+	}
+	r.ETag = string(parts[0])
+	r.LastModified = time.Unix(unix, 0).UTC()
+	r.Body = parts[2]
+	return r, nil
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+func TestNotModified(t *testing.T) {
+	response := newCachedResponse([]byte(`{"status":"ok"}`))
+	modified := response.LastModified
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "no validators", want: false},
+		{name: "same etag", headers: map[string]string{fiber.HeaderIfNoneMatch: response.ETag}, want: true},
+		{name: "weak etag", headers: map[string]string{fiber.HeaderIfNoneMatch: "W/" + response.ETag}, want: true},
+		{name: "etag in a list", headers: map[string]string{fiber.HeaderIfNoneMatch: `"other", ` + response.ETag}, want: true},
+		{name: "any etag", headers: map[string]string{fiber.HeaderIfNoneMatch: "*"}, want: true},
+		{name: "other etag", headers: map[string]string{fiber.HeaderIfNoneMatch: `"other"`}, want: false},
+		{name: "unquoted etag", headers: map[string]string{fiber.HeaderIfNoneMatch: response.ETag[1 : len(response.ETag)-1]}, want: false},
+		{name: "not modified since", headers: map[string]string{fiber.HeaderIfModifiedSince: modified.Format(http.TimeFormat)}, want: true},
+		{name: "not modified since later", headers: map[string]string{fiber.HeaderIfModifiedSince: modified.Add(time.Hour).Format(http.TimeFormat)}, want: true},
+		{name: "modified since", headers: map[string]string{fiber.HeaderIfModifiedSince: modified.Add(-time.Second).Format(http.TimeFormat)}, want: false},
+		{name: "invalid date", headers: map[string]string{fiber.HeaderIfModifiedSince: "yesterday"}, want: false},
+		{
+			name: "if-none-match wins",
+			headers: map[string]string{
+				fiber.HeaderIfNoneMatch:     `"other"`,
+				fiber.HeaderIfModifiedSince: modified.Format(http.TimeFormat),
+			},
+			want: false,
+		},
+	}
+
+	app := fiber.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := app.AcquireCtx(&fasthttp.RequestCtx{})
+			defer app.ReleaseCtx(c)
+			for key, value := range tt.headers {
+				c.Request().Header.Set(key, value)
+			}
+			if got := response.notModified(c); got != tt.want {
+				t.Fatalf("notModified() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseCodec(t *testing.T) {
+	response := newCachedResponse([]byte("{\"name\":\"line\\nbreak\"}\n"))
+	data, err := responseCodec{}.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := responseCodec{}.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ETag != response.ETag || !got.LastModified.Equal(response.LastModified) || string(got.Body) != string(response.Body) {
+		t.Fatalf("round trip = %+v, want %+v", got, response)
+	}
+
+	for _, data := range []string{"", "etag", "etag\nnot a time\nbody"} {
+		if _, err := (responseCodec{}).Unmarshal([]byte(data)); err != errResponseFormat {
+			t.Fatalf("Unmarshal(%q): err = %v, want errResponseFormat", data, err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+//	request deadline
+
+// Deadline gives every request a context (c.UserContext) that expires after
+// timeout; the handlers pass it down the ports to GORM and Redis. A request
+// that runs out of time is answered 504. A timeout of 0 disables it.
+func Deadline(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if timeout <= 0 {
+			return c.Next()
+		}
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+		if err == nil {
+			return nil
+		}
+		// drivers do not all wrap the context error (an i/o timeout, an
+		// interrupted query), so an expired context decides as well
+		var fiberErr *fiber.Error
+		if errors.Is(err, context.DeadlineExceeded) ||
+			(errors.Is(ctx.Err(), context.DeadlineExceeded) && !errors.As(err, &fiberErr)) {
+			return fiber.ErrGatewayTimeout
+		}
+		return err
+	}
+}
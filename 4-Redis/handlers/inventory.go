@@ -0,0 +1,10 @@
+package handlers
+
+import "github.com/gofiber/fiber/v2"
+
+type InventoryHandler interface {
+	Reserve(c *fiber.Ctx) error
+	Release(c *fiber.Ctx) error
+	Commit(c *fiber.Ctx) error
+	ChangeStock(c *fiber.Ctx) error
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"goredis/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type inventoryHandler struct {
+	inventorySrv services.InventoryService
+}
+
+func NewInventoryHandler(inventorySrv services.InventoryService) InventoryHandler {
+	return inventoryHandler{inventorySrv: inventorySrv}
+}
+
+func (h inventoryHandler) Reserve(c *fiber.Ctx) error {
+	id, input, err := reservationInput(c)
+	if err != nil {
+		return err
+	}
+
+	reservation, err := h.inventorySrv.Reserve(c.UserContext(), id, input)
+	if err != nil {
+		return inventoryError(err)
+	}
+
+	c.Status(fiber.StatusCreated)
+	return c.JSON(fiber.Map{
+		"status":      "ok",
+		"reservation": reservation,
+	})
+}
+
+func (h inventoryHandler) Release(c *fiber.Ctx) error {
+	id, input, err := reservationInput(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.inventorySrv.Release(c.UserContext(), id, input); err != nil {
+		return inventoryError(err)
+	}
+
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+func (h inventoryHandler) Commit(c *fiber.Ctx) error {
+	id, input, err := reservationInput(c)
+	if err != nil {
+		return err
+	}
+
+	product, err := h.inventorySrv.Commit(c.UserContext(), id, input)
+	if err != nil {
+		return inventoryError(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "ok",
+		"product": product,
+	})
+}
+
+func (h inventoryHandler) ChangeStock(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	input := services.StockChangeInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.ErrUnprocessableEntity
+	}
+
+	change, err := h.inventorySrv.ChangeStock(c.UserContext(), id, input)
+	if err != nil {
+		return inventoryError(err)
+	}
+
+	c.Status(fiber.StatusAccepted)
+	return c.JSON(fiber.Map{
+		"status": "accepted",
+		"change": change,
+	})
+}
+
+//	helper
+
+func reservationInput(c *fiber.Ctx) (id int, input services.ReservationInput, err error) {
+	if id, err = c.ParamsInt("id"); err != nil {
+		return id, input, fiber.ErrBadRequest
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return id, input, fiber.ErrUnprocessableEntity
+	}
+	return id, input, nil
+}
+
+func inventoryError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrInsufficientStock):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrReservationNotFound):
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrInvalidReservation), errors.Is(err, services.ErrInvalidStockChange):
+		return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, services.ErrWriteBehindDisabled):
+		return fiber.NewError(fiber.StatusNotImplemented, err.Error())
+	}
+	return serviceError(err)
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
-	"goredis/handlers"
-	"goredis/repositories"
-	"goredis/services"
+	"context"
+	"fmt"
+	"goredis/app"
+	"goredis/cache"
+	"goredis/config"
+	"goredis/metrics"
+	"goredis/tracing"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/go-redis/redis/v9"
-	"github.com/gofiber/fiber/v2"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
@@ -63,13 +70,37 @@ func main() {
 	//	install gorm				-> go get gorm.io/gorm
 	// 	install driver (Maria DB)	-> go get gorm.io/driver/mysql
 	//	install redis				-> go get github.com/go-redis/redis/v9
-	//	run service					-> go run .
+	//	run service					-> go run . (settings : config.yml / env / flags, see config/config.go)
 	//	test service				-> curl localhost:8000/products
+	//	query products				-> curl 'localhost:8000/products?limit=10&offset=20&sort=name&order=asc&name=Product1&min_quantity=10&max_quantity=50'
+	//	conditional get (handler)	-> curl -i localhost:8000/products -H 'If-None-Match: "<etag>"' (304)
+	//	codec benchmarks			-> go test ./cache -run '^$' -bench Codec -benchmem (json / msgpack / gob / protobuf x none / snappy / zstd)
+	//	prometheus					-> curl localhost:8000/metrics (goredis_cache_requests_total{layer,family,result},
+	//								   goredis_redis_command_duration_seconds, goredis_gorm_query_duration_seconds,
+	//								   goredis_http_request_duration_seconds)
+	//	cache stats					-> curl localhost:8000/debug/vars (cache.service.avoided = db calls saved,
+	//								   cache.service.l1_hits / l2_hits = hits per tier)
+	//	one product					-> curl localhost:8000/products/1 (404 for an unknown id)
+	//	products of a category		-> curl 'localhost:8000/categories/1/products?sort=price&order=asc' (404 for an unknown category)
+	//	rank of a product			-> curl localhost:8000/products/1/rank (quantity desc, id asc)
+	//	leaderboard rebuild			-> go run ./cmd/leaderboard (re-sync the ZSET from the products table)
+	//	reserve stock				-> curl -X POST localhost:8000/products/1/reserve -d '{"quantity":2}' -H 'Content-Type: application/json'
+	//								-> curl -X POST localhost:8000/products/1/commit -d '{"reservation":"<id>"}' -H ... (persisted)
+	//								-> curl -X POST localhost:8000/products/1/release -d '{"reservation":"<id>"}' -H ...
+	//	reservation check			-> go test ./repositories -run TestReserveNoOversell (1000 concurrent clients, no oversell)
+	//	stock change (queued)		-> curl -X POST localhost:8000/products/1/stock -d '{"delta":-1}' -H ... (202)
+	//								-> redis-cli xinfo groups stock::changes (pending / lag)
+	//	rate limit					-> RATELIMIT_ENABLED=true go run . (rules in config.yml : per route, per ip / api key / jwt sub)
+	//								-> curl -i localhost:8000/products (X-RateLimit-Limit / Remaining / Reset, 429 + Retry-After)
+	//	write product				-> curl -X POST localhost:8000/products -d '{"name":"Product","quantity":10,"price":1999,"category_id":1}' -H 'Content-Type: application/json'
+	//								-> curl -X PATCH localhost:8000/products/1 -d '{"quantity":99}' -H 'Content-Type: application/json'
+	//								-> curl -X DELETE localhost:8000/products/1
 
 	//	install redis local			-> brew install redis
 	//	use redis server			-> redis-server
 	//  use redis cli				-> redis-cli (guide : redis-cli --help)
-	//								-> get repository::GetProducts (key)
+	//								-> keys repository::GetProducts::* (one key per query page)
+	//								-> zrevrange {leaderboard}::products 0 19 withscores (top 20 ids)
 
 	/* 	--------------- Redis--------------- */
 
@@ -106,6 +137,9 @@ func main() {
 	//					-> paste -> load -> k6 select InfluxDB (default)
 	// 	then run services + test
 
+	//	Without the containers : go run ./cmd/bench (every layer in-process, SQLite + miniredis,
+	//	p50 / p95 / p99, req/s and db queries per layer, -json / -csv to export)
+
 	/*  --------------- Maria DB --------------- */
 
 	//	: relational database (use instead MySQL)
@@ -123,37 +157,193 @@ func main() {
 	//	-> port : handlers (fiber) 					[]:		=- adapter : handler
 	//														=- adapter : handler with redis
 
-	//	!!! However we will use redis only one
+	//	!!! However we will use redis only one (pick it with --cache-layer, see Action Zone)
+
+	//	Redis down : every redis call goes through a circuit breaker (cache.Breaker), the
+	//	adapters fall through to the database while it is open, so an outage is only slower
+	//	-> curl localhost:8000/debug/vars (redis_breaker.state / opened / rejected)
+
+	//	Stampede protection (service with redis) : on a miss concurrent requests share one load
+	//	(singleflight) and one instance rebuilds under a redis lock while others wait briefly
+
+	//	Stale-while-revalidate (service / handler with redis) : after the 10s TTL an entry is
+	//	still served for 20s while one background refresh runs, hot keys refresh early (XFetch)
+
+	//	The redis adapters are decorators built on cache.Aside (get -> miss -> load -> set):
+	//	repository with redis wraps a repository, service with redis wraps a service
+	//	and handler with redis wraps the service it renders
+
+	//	Leaderboard (repository) : a redis ZSET of product ids scored by quantity plus one hash
+	//	per product, updated on every write, serves the top-N listing and /products/:id/rank
+	//	without the database (other filters / sorts still read it); rebuilt at startup, the
+	//	writes made during a rebuild go to the new ZSET too
+
+	//	Reservations : a lua script checks and holds stock in one step (stock::quantity mirrors
+	//	the table, stock::held / stock::expiry are the holds), unreleased holds expire after
+	//	inventory.reservationTTL and a commit takes the quantity out of the products table
+
+	//	Write-behind (stock changes) : deltas go to the stock::changes stream, a writer per
+	//	instance (consumer group) applies them in batches and records each entry id in the
+	//	stock_changes table in the same transaction, so redelivered entries are skipped;
+	//	failed batches stay pending and are claimed later (XAUTOCLAIM), shutdown drains
+
+	//	Rate limiting : a token bucket per rule and client in redis (ratelimit::<rule>::<client>),
+	//	refilled by a lua script on the redis clock so every instance shares the same limits
+
+	//	Sentinel / Cluster : every adapter takes a redis.UniversalClient, keys that scripts or
+	//	MULTI use together share a hash tag ({leaderboard}, stock::...::{<id>}) and prefix
+	//	deletes SCAN every master
+
+	//	Warm-up : at startup the first pages of the listing (and cache.warmup.queries) are loaded
+	//	into every cache layer, innermost first, GET /readyz answers 503 until that is done;
+	//	cache.warmup.refreshInterval reloads them before the TTL runs out
+	//	-> curl -i localhost:8000/readyz
+
+	//	Health : GET /healthz is 200 while the process serves (liveness), GET /readyz pings the
+	//	database and redis ({"status","checks":{"database":{"status":"up","latency_ms":..}}}) and
+	//	is 503 while a required one is down or the warm-up runs; health.redisOptional keeps it
+	//	ready without redis (every layer reads the database). MariaDB down at startup no longer
+	//	panics : the app starts unready and the schema is migrated once the database answers
+	//	-> curl -i localhost:8000/healthz
+
+	//	Tracing (OpenTelemetry) : a span per request (GET /products/:id, child of an incoming
+	//	traceparent), per CatalogService and ProductRepository call, per cache lookup
+	//	(cache.Get : cache.layer, cache.result hit / miss / stale, cache.source l1 / redis / load),
+	//	per GORM statement and per redis command; tracing.exporter none | otlp | stdout | memory
+	//	-> go run . --tracing-exporter=stdout
+	//	-> docker run -p 16686:16686 -p 4318:4318 jaegertracing/all-in-one, go run . --tracing-exporter=otlp
+	//	-> curl localhost:8000/products -H 'traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01'
+
+	//	Deadlines : every port takes the request context, app.requestTimeout (3s) bounds the
+	//	GORM queries (WithContext) and redis calls made for a request, past it -> 504
+	//	-> go run . --request-timeout=50ms
+
+	//	Admin API (ADMIN_TOKEN=... go run .) : cached keys per layer / prefix with TTL, size and
+	//	codec, decoded values, flushes by key or prefix (SCAN, never KEYS, L1 of every instance
+	//	included), each flush recorded in the admin::audit stream
+	//	-> curl -H 'Authorization: Bearer <token>' 'localhost:8000/admin/cache/keys?layer=service'
+	//	-> curl -H ... 'localhost:8000/admin/cache/value?key=service::GetProducts::limit=20%26offset=0%26order=desc%26sort=quantity'
+	//	-> curl -X DELETE -H ... 'localhost:8000/admin/cache/keys?prefix=handler::GetProducts'
+	//	-> curl -H ... localhost:8000/admin/audit
+
+	//	Product detail (GET /products/:id) : one key per id in each layer (service::GetProduct::42),
+	//	an unknown id is cached as "missing" for cache.missingTTL (5s) so repeated misses skip
+	//	the database, and a Bloom filter of the product ids ({bloom::products}::m=...&k=...,
+	//	a redis bitmap built at startup and every cache.bloom.rebuildInterval, new ids added
+	//	on create) answers 404 for ids that never existed before any cache or query
+	//	(cache.bloom.enabled, sized by capacity and falsePositiveRate; redis down -> the
+	//	lookup goes through, a failed add drops the filter until the next rebuild)
+
+	//	Writes (POST / PUT / PATCH / DELETE) go through the same chain and every
+	//	redis adapter drops, after the write is saved, the cached entries it made stale
+	//	in every layer (repository::, service::, handler::), so changes show up immediately
+
+	//	Tags : each cached entry is added to redis sets naming what it was built from,
+	//	{tag::product:42} (the detail and the pages showing it), {tag::catalog:list} (every
+	//	page) and {tag::catalog:list:quantity} / :name (pages filtered or sorted on it);
+	//	a create / delete drops catalog:list, an update only product:<id> and the fields
+	//	it wrote, in one lua script (cluster : SPOP + DEL per key); expired keys are pruned
+	//	from the sets every cache.tagPruneInterval
+	//	-> redis-cli smembers '{tag::product:42}'
+	//	-> curl -X DELETE -H 'Authorization: Bearer <token>' 'localhost:8000/admin/cache/keys?tag=product:42'
+
+	//	Products have a price (cents), a unique sku, created_at / updated_at and a category
+	//	(categories table, preloaded with the product, category_id 0 in a write removes it);
+	//	a products table of the first version is upgraded at startup (columns added, sku
+	//	SKU-<id>, price 0, no category); listings of a category are cached under their own
+	//	keys (service::GetProducts::category=7::limit=...) and tagged category:7
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+
+	tracer, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		ServiceName: cfg.Tracing.ServiceName,
+	})
+	if err != nil {
+		panic(err)
+	}
+	//	flush the spans still buffered on exit
+	defer tracer.Shutdown(context.Background())
 
-	db := initDatabase()
-	redisClient := initRedis()
-	_ = redisClient
+	db := initDatabase(cfg.DB)
+	redisClient := initRedis(cfg.Redis)
 
 	// 	Action Zone //
 
-	productRepo := repositories.NewProductRepositoryDB(db)
-	// productRepo := repositories.NewProductRepositoryRedis(db, redisClient)
-	// productService := services.NewCatalogService(productRepo)
-	productService := services.NewCatalogServiceRedis(productRepo, redisClient) // recommend
-	productHandler := handlers.NewCatalogHandler(productService)
-	// productHandler := handlers.NewCatalogHanlderRedis(productService, redisClient)
+	//	no more commenting code : choose the adapters at runtime
+	//	-> go run . --cache-layer=none				(no redis)
+	//	-> go run . --cache-layer=repository		(repository with redis)
+	//	-> go run . --cache-layer=service			(service with redis, default / recommend)
+	//	-> go run . --cache-layer=handler			(handler with redis)
+	//	-> go run . --cache-layer=all				(combined, or e.g. repository,handler)
+	//	-> go run . --cache-layer=leaderboard,service	(ZSET leaderboard under the service cache)
+	//	same settings from config.yml or env (CACHE_LAYER=handler, DB_DSN=..., REDIS_ADDR=...)
+	//	-> go run . --redis-mode=cluster --redis-addrs=node1:6379,node2:6379	(sentinel : + REDIS_MASTERNAME)
+
+	//	ctrl+c / SIGTERM : stop taking requests, then drain the write-behind stream
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server, err := app.New(ctx, cfg, db, redisClient)
+	if err != nil {
+		panic(err)
+	}
 
-	app := fiber.New()
-	app.Get("/products", productHandler.GetProducts)
-	app.Listen(":8000")
+	shutdown := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		server.Shutdown()
+		close(shutdown)
+	}()
+	if err := server.Listen(fmt.Sprintf(":%v", cfg.App.Port)); err != nil {
+		panic(err)
+	}
+	<-shutdown
 }
 
-func initDatabase() *gorm.DB {
-	dial := mysql.Open("root:pass@tcp(127.0.0.1:3306)/testdb2?parseTime=True")
+func initDatabase(cfg config.DBConfig) *gorm.DB {
+	dial := mysql.Open(cfg.DSN)
 	db, err := gorm.Open(dial, &gorm.Config{})
 	if err != nil {
+		//	database down : start anyway (GET /readyz is 503), the pool connects when it is back
+		log.Println("database:", err)
+		dial = mysql.New(mysql.Config{DSN: cfg.DSN, SkipInitializeWithVersion: true})
+		if db, err = gorm.Open(dial, &gorm.Config{DisableAutomaticPing: true}); err != nil {
+			panic(err)
+		}
+	}
+	if err := db.Use(metrics.GormPlugin{}); err != nil {
+		panic(err)
+	}
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
 		panic(err)
 	}
 	return db
 }
 
-func initRedis() *redis.Client {
-	return redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-	})
+func initRedis(cfg config.RedisConfig) redis.UniversalClient {
+	//	standalone / sentinel / cluster (redis.mode), fail fast : a slow cache is worse than no cache
+	redisClient := cfg.NewClient()
+
+	//	circuit breaker : after n failures skip redis for a while, then probe once
+	redisClient.AddHook(cache.NewBreaker(cache.BreakerOptions{
+		Failures:    cfg.Breaker.Failures,
+		OpenTimeout: cfg.Breaker.OpenTimeout,
+		Probes:      1,
+		OnRecover: func() {
+			//	writes during the outage could not invalidate anything
+			cache.InvalidateCatalog(context.Background(), redisClient)
+		},
+	}))
+	//	latency per command (after the breaker : rejected commands are not timed)
+	redisClient.AddHook(metrics.RedisHook{})
+	//	a span per command, under the span of the request
+	redisClient.AddHook(tracing.RedisHook{})
+	return redisClient
 }
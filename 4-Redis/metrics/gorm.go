@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//	gorm plugin
+
+const gormStartKey = "metrics:start"
+
+// GormPlugin records GormDuration for every statement; register it with
+// db.Use(metrics.GormPlugin{}).
+type GormPlugin struct{}
+
+func (GormPlugin) Name() string {
+	return "metrics"
+}
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	register := []func() error{
+		func() error {
+			return callbacks.Create().Before("gorm:create").Register("metrics:before_create", before)
+		},
+		func() error {
+			return callbacks.Create().After("gorm:create").Register("metrics:after_create", after("create"))
+		},
+		func() error { return callbacks.Query().Before("gorm:query").Register("metrics:before_query", before) },
+		func() error {
+			return callbacks.Query().After("gorm:query").Register("metrics:after_query", after("query"))
+		},
+		func() error {
+			return callbacks.Update().Before("gorm:update").Register("metrics:before_update", before)
+		},
+		func() error {
+			return callbacks.Update().After("gorm:update").Register("metrics:after_update", after("update"))
+		},
+		func() error {
+			return callbacks.Delete().Before("gorm:delete").Register("metrics:before_delete", before)
+		},
+		func() error {
+			return callbacks.Delete().After("gorm:delete").Register("metrics:after_delete", after("delete"))
+		},
+		func() error { return callbacks.Row().Before("gorm:row").Register("metrics:before_row", before) },
+		func() error { return callbacks.Row().After("gorm:row").Register("metrics:after_row", after("row")) },
+		func() error { return callbacks.Raw().Before("gorm:raw").Register("metrics:before_raw", before) },
+		func() error { return callbacks.Raw().After("gorm:raw").Register("metrics:after_raw", after("raw")) },
+	}
+	for _, fn := range register {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func before(db *gorm.DB) {
+	db.InstanceSet(gormStartKey, time.Now())
+}
+
+func after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(gormStartKey)
+		if !ok {
+			return
+		}
+		err := db.Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = nil
+		}
+		GormDuration.
+			WithLabelValues(operation, db.Statement.Table, status(err)).
+			Observe(time.Since(value.(time.Time)).Seconds())
+	}
+}
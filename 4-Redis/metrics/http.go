@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+//	fiber middleware
+
+// Middleware records HandlerDuration labelled with the route pattern
+// (/products/:id), never the raw path, to keep the label set bounded.
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		code := c.Response().StatusCode()
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			code = fiberErr.Code
+		} else if err != nil {
+			code = fiber.StatusInternalServerError
+		}
+
+		HandlerDuration.
+			WithLabelValues(c.Method(), c.Route().Path, strconv.Itoa(code)).
+			Observe(time.Since(start).Seconds())
+		return err
+	}
+}
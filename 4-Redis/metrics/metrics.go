@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+//	collectors
+
+var (
+	// CacheRequests counts cache lookups by layer (repository, service,
+	// handler), key family (e.g. service::GetProducts) and result.
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "goredis",
+		Name:      "cache_requests_total",
+		Help:      "Cache lookups by layer, key family and result (hit, miss, stale, error).",
+	}, []string{"layer", "family", "result"})
+
+	RedisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "goredis",
+		Name:      "redis_command_duration_seconds",
+		Help:      "Redis command latency.",
+		Buckets:   []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	}, []string{"command", "status"})
+
+	GormDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "goredis",
+		Name:      "gorm_query_duration_seconds",
+		Help:      "GORM statement latency.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation", "table", "status"})
+
+	HandlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "goredis",
+		Name:      "http_request_duration_seconds",
+		Help:      "End-to-end handler latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// StockChanges counts write-behind stream entries by what the writer did
+	// with them (flushed, failed, claimed, invalid).
+	StockChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "goredis",
+		Name:      "stock_changes_total",
+		Help:      "Write-behind stock change entries by result.",
+	}, []string{"result"})
+
+	// RateLimited counts the requests a rate limit rule applied to, by
+	// result (allowed, limited, error).
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "goredis",
+		Name:      "ratelimit_requests_total",
+		Help:      "Rate limited requests by rule and result (allowed, limited, error).",
+	}, []string{"rule", "result"})
+)
+
+const (
+	Hit   = "hit"
+	Miss  = "miss"
+	Stale = "stale"
+	Error = "error"
+)
+
+func status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+//	endpoint
+
+// Handler serves the default registry in the Prometheus text format.
+func Handler() fiber.Handler {
+	handler := fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())
+	return func(c *fiber.Ctx) error {
+		handler(c.Context())
+		return nil
+	}
+}
@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+//	client keys
+
+type KeyKind string
+
+const (
+	// KeyIP limits per client address.
+	KeyIP KeyKind = "ip"
+	// KeyAPIKey limits per API key header, if it is one of the configured
+	// keys, or per address without one.
+	KeyAPIKey KeyKind = "apikey"
+	// KeyJWT limits per subject of a valid, unexpired HS256 bearer token, or
+	// per address without one.
+	KeyJWT KeyKind = "jwt"
+)
+
+type KeyOptions struct {
+	// APIKeyHeader defaults to X-API-Key.
+	APIKeyHeader string
+	// APIKeys are the keys clients may send. Any other value is ignored,
+	// like the unverified subject below: a client could send a new one per
+	// request.
+	APIKeys []string
+	// JWTSecret verifies bearer tokens. An unverified subject would let a
+	// client pick a new one per request, so jwt keys need it.
+	JWTSecret string
+}
+
+func (o KeyOptions) check(kind KeyKind) error {
+	switch kind {
+	case "", KeyIP, KeyAPIKey:
+		return nil
+	case KeyJWT:
+		if o.JWTSecret == "" {
+			return errors.New("ratelimit: jwt keys need a jwt secret")
+		}
+		return nil
+	}
+	return fmt.Errorf("ratelimit: unknown key %q (ip|apikey|jwt)", kind)
+}
+
+// client names the bucket of the client making the request.
+func (o KeyOptions) client(c *fiber.Ctx, kind KeyKind) string {
+	switch kind {
+	case KeyAPIKey:
+		header := o.APIKeyHeader
+		if header == "" {
+			header = "X-API-Key"
+		}
+		if key := c.Get(header); key != "" && o.knownAPIKey(key) {
+			// the key is a secret, Redis only sees a digest of it
+			return "apikey:" + digest(key)
+		}
+	case KeyJWT:
+		if subject, err := o.subject(c.Get(fiber.HeaderAuthorization)); err == nil {
+			return "jwt:" + subject
+		}
+	}
+	return "ip:" + c.IP()
+}
+
+func (o KeyOptions) knownAPIKey(key string) bool {
+	known := false
+	for _, apiKey := range o.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			known = true
+		}
+	}
+	return known
+}
+
+// digest is the first 16 hex digits of the sha256 of key.
+func digest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// subject returns the sub claim of an HS256 "Bearer <jwt>" that has not
+// expired (exp is optional).
+func (o KeyOptions) subject(authorization string) (string, error) {
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	parts := strings.Split(token, ".")
+	if token == authorization || len(parts) != 3 {
+		return "", errors.New("no bearer token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return "", errors.New("unsupported token")
+	}
+
+	mac := hmac.New(sha256.New, []byte(o.JWTSecret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", errors.New("invalid signature")
+	}
+
+	var claims struct {
+		Sub string   `json:"sub"`
+		Exp *float64 `json:"exp"`
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil || claims.Sub == "" {
+		return "", errors.New("no subject")
+	}
+	if claims.Exp != nil && float64(time.Now().Unix()) >= *claims.Exp {
+		return "", errors.New("expired token")
+	}
+	return claims.Sub, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// token signs claims with HS256 (or returns them with alg, unsigned).
+func token(t *testing.T, alg string, secret string, claims map[string]interface{}) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	unsigned := segment(map[string]string{"alg": alg, "typ": "JWT"}) + "." + segment(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestClientKey(t *testing.T) {
+	options := KeyOptions{APIKeys: []string{"key-1", "key-2"}, JWTSecret: "secret"}
+	hour := time.Hour.Seconds()
+	now := float64(time.Now().Unix())
+
+	tests := []struct {
+		name    string
+		kind    KeyKind
+		headers map[string]string
+		want    string
+	}{
+		{name: "ip", kind: KeyIP, want: "ip:0.0.0.0"},
+		{name: "api key", kind: KeyAPIKey, headers: map[string]string{"X-API-Key": "key-2"}, want: "apikey:" + digest("key-2")},
+		{name: "unknown api key", kind: KeyAPIKey, headers: map[string]string{"X-API-Key": "made-up"}, want: "ip:0.0.0.0"},
+		{name: "no api key", kind: KeyAPIKey, want: "ip:0.0.0.0"},
+		{name: "api key of an ip rule", kind: KeyIP, headers: map[string]string{"X-API-Key": "key-1"}, want: "ip:0.0.0.0"},
+		{
+			name:    "jwt",
+			kind:    KeyJWT,
+			headers: map[string]string{fiber.HeaderAuthorization: "Bearer " + token(t, "HS256", "secret", map[string]interface{}{"sub": "alice", "exp": now + hour})},
+			want:    "jwt:alice",
+		},
+		{
+			name:    "jwt without exp",
+			kind:    KeyJWT,
+			headers: map[string]string{fiber.HeaderAuthorization: "Bearer " + token(t, "HS256", "secret", map[string]interface{}{"sub": "alice"})},
+			want:    "jwt:alice",
+		},
+		{
+			name:    "expired jwt",
+			kind:    KeyJWT,
+			headers: map[string]string{fiber.HeaderAuthorization: "Bearer " + token(t, "HS256", "secret", map[string]interface{}{"sub": "alice", "exp": now - 1})},
+			want:    "ip:0.0.0.0",
+		},
+		{
+			name:    "jwt of another secret",
+			kind:    KeyJWT,
+			headers: map[string]string{fiber.HeaderAuthorization: "Bearer " + token(t, "HS256", "other", map[string]interface{}{"sub": "alice"})},
+			want:    "ip:0.0.0.0",
+		},
+		{
+			name:    "unsigned jwt",
+			kind:    KeyJWT,
+			headers: map[string]string{fiber.HeaderAuthorization: "Bearer " + token(t, "none", "secret", map[string]interface{}{"sub": "alice"})},
+			want:    "ip:0.0.0.0",
+		},
+		{
+			name:    "jwt without subject",
+			kind:    KeyJWT,
+			headers: map[string]string{fiber.HeaderAuthorization: "Bearer " + token(t, "HS256", "secret", map[string]interface{}{"exp": now + hour})},
+			want:    "ip:0.0.0.0",
+		},
+		{name: "not a bearer token", kind: KeyJWT, headers: map[string]string{fiber.HeaderAuthorization: "Basic YWxpY2U6c2VjcmV0"}, want: "ip:0.0.0.0"},
+	}
+
+	app := fiber.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := app.AcquireCtx(&fasthttp.RequestCtx{})
+			defer app.ReleaseCtx(c)
+			for key, value := range tt.headers {
+				c.Request().Header.Set(key, value)
+			}
+			if got := options.client(c, tt.kind); got != tt.want {
+				t.Fatalf("client() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientKeyHidesAPIKey(t *testing.T) {
+	const apiKey = "sk_live_0123456789abcdef"
+	app := fiber.New()
+	c := app.AcquireCtx(&fasthttp.RequestCtx{})
+	defer app.ReleaseCtx(c)
+	c.Request().Header.Set("X-API-Key", apiKey)
+
+	key := KeyOptions{APIKeys: []string{apiKey}}.client(c, KeyAPIKey)
+	if strings.Contains(key, apiKey) || len(key) != len("apikey:")+16 {
+		t.Fatalf("client() = %q, want apikey:<16 hex digits>", key)
+	}
+}
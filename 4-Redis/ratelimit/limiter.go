@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"goredis/cache"
+	"goredis/metrics"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+//	rules
+
+// Rule limits the requests matching Method and Path to Limit per Window for
+// each client, with bursts of up to Burst (defaults to Limit). Path uses the
+// route syntax: "/products/:id/rank" matches any id, a trailing "*" any rest.
+type Rule struct {
+	Name   string
+	Method string
+	Path   string
+	Key    KeyKind
+	Limit  int
+	Window time.Duration
+	Burst  int
+}
+
+func (r Rule) matches(method string, path string) bool {
+	if r.Method != "" && r.Method != "*" && !strings.EqualFold(r.Method, method) {
+		return false
+	}
+	if r.Path == "" || r.Path == "*" {
+		return true
+	}
+
+	pattern := strings.Split(strings.Trim(r.Path, "/"), "/")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range pattern {
+		if part == "*" {
+			return true
+		}
+		if i >= len(segments) || (!strings.HasPrefix(part, ":") && part != segments[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(segments)
+}
+
+// rate is the refill in tokens per millisecond.
+func (r Rule) rate() float64 {
+	return float64(r.Limit) * float64(time.Millisecond) / float64(r.Window)
+}
+
+type Options struct {
+	// Rules are tried in order, the first one matching a request applies;
+	// requests matching none are not limited.
+	Rules []Rule
+	// KeyOptions tell how clients are identified.
+	KeyOptions KeyOptions
+	// FailClosed rejects requests with 503 when Redis is unavailable; by
+	// default they are let through.
+	FailClosed bool
+}
+
+//	token bucket
+
+// bucketScript refills the bucket for the time elapsed since the last call
+// (by the Redis clock, so every instance agrees) and takes one token. It
+// returns {allowed, remaining, retry after ms, full again in ms}.
+var bucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed, retry = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+local full = math.ceil((burst - tokens) / rate)
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.max(full, 1000))
+return {allowed, math.floor(tokens), retry, full}
+`)
+
+//	middleware
+
+// New returns a Fiber middleware enforcing the rules. The counters live in
+// Redis so every goredis instance shares them.
+func New(redisClient redis.UniversalClient, options Options) (fiber.Handler, error) {
+	for i := range options.Rules {
+		rule := &options.Rules[i]
+		// the bucket refills by the millisecond of the redis clock
+		if rule.Limit <= 0 || rule.Window < time.Millisecond {
+			return nil, fmt.Errorf("ratelimit: rule %q needs a limit and a window of at least 1ms", rule.Name)
+		}
+		if rule.Burst <= 0 {
+			rule.Burst = rule.Limit
+		}
+		if rule.Name == "" {
+			rule.Name = strings.TrimSpace(rule.Method + " " + rule.Path)
+		}
+		if err := options.KeyOptions.check(rule.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(c *fiber.Ctx) error {
+		for _, rule := range options.Rules {
+			if rule.matches(c.Method(), c.Path()) {
+				return limit(c, redisClient, rule, options)
+			}
+		}
+		return c.Next()
+	}, nil
+}
+
+func limit(c *fiber.Ctx, redisClient redis.UniversalClient, rule Rule, options Options) error {
+	key := cache.Key("ratelimit", rule.Name, options.KeyOptions.client(c, rule.Key))
+
+	result, err := bucketScript.Run(c.UserContext(), redisClient, []string{key}, rule.rate(), rule.Burst).Int64Slice()
+	if err != nil {
+		metrics.RateLimited.WithLabelValues(rule.Name, metrics.Error).Inc()
+		if !errors.Is(err, cache.ErrCircuitOpen) {
+			log.Printf("ratelimit: %v: %v", rule.Name, err)
+		}
+		if options.FailClosed {
+			return fiber.ErrServiceUnavailable
+		}
+		return c.Next()
+	}
+	allowed, remaining, retry, full := result[0] == 1, result[1], result[2], result[3]
+
+	c.Set("X-RateLimit-Limit", strconv.Itoa(rule.Burst))
+	c.Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+	c.Set("X-RateLimit-Reset", strconv.FormatInt(seconds(full), 10))
+	if !allowed {
+		metrics.RateLimited.WithLabelValues(rule.Name, "limited").Inc()
+		c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(seconds(retry), 10))
+		return fiber.ErrTooManyRequests
+	}
+	metrics.RateLimited.WithLabelValues(rule.Name, "allowed").Inc()
+	return c.Next()
+}
+
+// seconds rounds up: a client told 0 would retry at once.
+func seconds(ms int64) int64 {
+	return int64(math.Ceil(float64(ms) / 1000))
+}
@@ -0,0 +1,188 @@
+package ratelimit
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, redis.UniversalClient) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+	return mr, redisClient
+}
+
+func newTestApp(t *testing.T, redisClient redis.UniversalClient, options Options) *fiber.App {
+	t.Helper()
+	limiter, err := New(redisClient, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Use(limiter)
+	app.All("/*", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	return app
+}
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   Rule
+		method string
+		path   string
+		want   bool
+	}{
+		{name: "any request", rule: Rule{}, method: "GET", path: "/products", want: true},
+		{name: "same path", rule: Rule{Method: "GET", Path: "/products"}, method: "GET", path: "/products", want: true},
+		{name: "method is case insensitive", rule: Rule{Method: "post", Path: "/products"}, method: "POST", path: "/products", want: true},
+		{name: "other method", rule: Rule{Method: "POST", Path: "/products"}, method: "GET", path: "/products", want: false},
+		{name: "any method", rule: Rule{Method: "*", Path: "/products"}, method: "DELETE", path: "/products", want: true},
+		{name: "trailing slash", rule: Rule{Path: "/products"}, method: "GET", path: "/products/", want: true},
+		{name: "parameter", rule: Rule{Path: "/products/:id/rank"}, method: "GET", path: "/products/42/rank", want: true},
+		{name: "parameter, shorter path", rule: Rule{Path: "/products/:id/rank"}, method: "GET", path: "/products/42", want: false},
+		{name: "longer path", rule: Rule{Path: "/products"}, method: "GET", path: "/products/42", want: false},
+		{name: "wildcard rest", rule: Rule{Path: "/admin/*"}, method: "GET", path: "/admin/cache/keys", want: true},
+		{name: "other path", rule: Rule{Path: "/products"}, method: "GET", path: "/categories", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.matches(tt.method, tt.path); got != tt.want {
+				t.Fatalf("matches(%v, %v) = %v, want %v", tt.method, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewChecksRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		wantErr bool
+	}{
+		{name: "valid", options: Options{Rules: []Rule{{Limit: 1, Window: time.Second}}}},
+		{name: "no limit", options: Options{Rules: []Rule{{Window: time.Second}}}, wantErr: true},
+		{name: "no window", options: Options{Rules: []Rule{{Limit: 1}}}, wantErr: true},
+		{name: "window under 1ms", options: Options{Rules: []Rule{{Limit: 1, Window: 500 * time.Microsecond}}}, wantErr: true},
+		{name: "window of 1ms", options: Options{Rules: []Rule{{Limit: 1, Window: time.Millisecond}}}},
+		{name: "unknown key", options: Options{Rules: []Rule{{Limit: 1, Window: time.Second, Key: "cookie"}}}, wantErr: true},
+		{name: "jwt without a secret", options: Options{Rules: []Rule{{Limit: 1, Window: time.Second, Key: KeyJWT}}}, wantErr: true},
+		{
+			name:    "jwt with a secret",
+			options: Options{Rules: []Rule{{Limit: 1, Window: time.Second, Key: KeyJWT}}, KeyOptions: KeyOptions{JWTSecret: "secret"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(nil, tt.options); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	app := newTestApp(t, redisClient, Options{Rules: []Rule{
+		{Name: "writes", Method: "POST", Path: "/products", Limit: 3, Window: time.Minute},
+	}})
+
+	tests := []struct {
+		method        string
+		path          string
+		wantStatus    int
+		wantRemaining string
+	}{
+		{method: "POST", path: "/products", wantStatus: fiber.StatusOK, wantRemaining: "2"},
+		{method: "POST", path: "/products", wantStatus: fiber.StatusOK, wantRemaining: "1"},
+		{method: "GET", path: "/products", wantStatus: fiber.StatusOK},
+		{method: "POST", path: "/products", wantStatus: fiber.StatusOK, wantRemaining: "0"},
+		{method: "POST", path: "/products", wantStatus: fiber.StatusTooManyRequests, wantRemaining: "0"},
+		{method: "GET", path: "/products", wantStatus: fiber.StatusOK},
+	}
+
+	for i, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest(tt.method, tt.path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.wantStatus {
+			t.Fatalf("request %d: status = %d, want %d", i, resp.StatusCode, tt.wantStatus)
+		}
+		if remaining := resp.Header.Get("X-RateLimit-Remaining"); remaining != tt.wantRemaining {
+			t.Fatalf("request %d: remaining = %q, want %q", i, remaining, tt.wantRemaining)
+		}
+		if tt.wantStatus == fiber.StatusTooManyRequests {
+			// one token every 20s
+			if retry, _ := strconv.Atoi(resp.Header.Get(fiber.HeaderRetryAfter)); retry < 1 || retry > 20 {
+				t.Fatalf("request %d: Retry-After = %q, want 1..20", i, resp.Header.Get(fiber.HeaderRetryAfter))
+			}
+		}
+	}
+}
+
+func TestLimiterRefills(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	app := newTestApp(t, redisClient, Options{Rules: []Rule{
+		{Name: "fast", Limit: 1, Window: 100 * time.Millisecond},
+	}})
+
+	status := func() int {
+		resp, err := app.Test(httptest.NewRequest("GET", "/products", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if got := status(); got != fiber.StatusOK {
+		t.Fatalf("first request: status = %d", got)
+	}
+	if got := status(); got != fiber.StatusTooManyRequests {
+		t.Fatalf("second request: status = %d, want 429", got)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if got := status(); got != fiber.StatusOK {
+		t.Fatalf("after the refill: status = %d, want 200", got)
+	}
+}
+
+func TestLimiterRedisDown(t *testing.T) {
+	tests := []struct {
+		name       string
+		failClosed bool
+		wantStatus int
+	}{
+		{name: "fail open", wantStatus: fiber.StatusOK},
+		{name: "fail closed", failClosed: true, wantStatus: fiber.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, redisClient := newTestRedis(t)
+			mr.Close()
+			app := newTestApp(t, redisClient, Options{
+				Rules:      []Rule{{Limit: 1, Window: time.Minute}},
+				FailClosed: tt.failClosed,
+			})
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/products", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
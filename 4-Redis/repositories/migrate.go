@@ -0,0 +1,79 @@
+package repositories
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//	migration
+
+var prepareRetry = 5 * time.Second
+
+// prepare migrates the schema then adds the mock data.
+func prepare(db *gorm.DB) error {
+	if err := migrate(db); err != nil {
+		return err
+	}
+	return mockData(db)
+}
+
+// migrate creates the tables, or upgrades a products table of the first
+// version (id, name, quantity). AutoMigrate alone cannot: it would add the
+// unique sku index while every existing row has the same empty sku. So the
+// new columns are added first, filled (sku "SKU-<id>", price 0, timestamps
+// now, no category), and the indexes and constraints created afterwards.
+func migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&Category{}, &stockChange{}); err != nil {
+		return err
+	}
+
+	migrator := db.Migrator()
+	if migrator.HasTable(&product{}) && !migrator.HasColumn(&product{}, "SKU") {
+		for _, field := range []string{"Price", "SKU", "CategoryID", "CreatedAt", "UpdatedAt"} {
+			if migrator.HasColumn(&product{}, field) {
+				continue
+			}
+			if err := migrator.AddColumn(&product{}, field); err != nil {
+				return fmt.Errorf("add %v: %w", field, err)
+			}
+		}
+		if err := backfillProducts(db); err != nil {
+			return err
+		}
+	}
+
+	return db.AutoMigrate(&product{})
+}
+
+// backfillProducts fills the new columns of the existing rows by batches,
+// each in a transaction of its own.
+func backfillProducts(db *gorm.DB) error {
+	now := time.Now()
+	ids := []int{}
+	for {
+		err := db.Model(&product{}).Where("sku IS NULL OR sku = ''").Order("id").Limit(1000).Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for _, id := range ids {
+				err := tx.Model(&product{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+					"sku":        fmt.Sprintf("SKU-%06d", id),
+					"price":      0,
+					"created_at": now,
+					"updated_at": now,
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		ids = ids[:0]
+	}
+}
@@ -1,6 +1,8 @@
 package repositories
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"time"
@@ -8,20 +10,96 @@ import (
 	"gorm.io/gorm"
 )
 
+// Category groups products (categories table); a product without one has a
+// NULL category_id.
+type Category struct {
+	ID   int
+	Name string `gorm:"size:50;uniqueIndex"`
+}
+
 type product struct {
-	ID       int
-	Name     string
-	Quantity int
+	ID         int
+	Name       string
+	Quantity   int
+	Price      int64     `gorm:"not null;default:0"` // in cents
+	SKU        string    `gorm:"size:32;uniqueIndex"`
+	CategoryID *int      `gorm:"index"`
+	Category   *Category // -> associate with categories table (preloaded)
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// ProductRow names the product the repositories return, so the services can
+// convert it in one place; the type itself stays unexported.
+type ProductRow = product
+
+// ProductDraft is a product to create. An empty SKU is generated.
+type ProductDraft struct {
+	Name       string
+	Quantity   int
+	Price      int64
+	SKU        string
+	CategoryID *int
+}
+
+type productPage struct {
+	Products []product
+	Total    int64
 }
 
 // 	port
 
+// ProductRepository methods take the request context: its deadline bounds
+// every database and Redis call made for the request.
 type ProductRepository interface {
-	GetProducts() ([]product, error)
+	GetProducts(ctx context.Context, query ProductQuery) (productPage, error)
+	GetProduct(ctx context.Context, id int) (product, error)
+	// GetProductRank is the 1-based position of a product in the default
+	// listing (quantity desc, id asc).
+	GetProductRank(ctx context.Context, id int) (int64, error)
+	CreateProduct(ctx context.Context, draft ProductDraft) (product, error)
+	UpdateProduct(ctx context.Context, id int, fields map[string]interface{}) (product, error)
+	DeleteProduct(ctx context.Context, id int) error
+	// DecrementQuantity takes quantity out of stock, failing with
+	// ErrInsufficientStock rather than going below zero.
+	DecrementQuantity(ctx context.Context, id int, quantity int) (product, error)
+	// ApplyStockChanges adds the deltas of the changes not applied before
+	// (a quantity never goes below zero) and returns the products changed.
+	ApplyStockChanges(ctx context.Context, changes []stockChange) ([]product, error)
+}
+
+var (
+	ErrProductNotFound   = errors.New("product not found")
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrCategoryNotFound  = errors.New("category not found")
+	ErrDuplicateSKU      = errors.New("sku already used")
+)
+
+func productIDs(products []product) []int {
+	ids := make([]int, 0, len(products))
+	for _, p := range products {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+// fieldNames are the columns of an UpdateProduct.
+func fieldNames(fields map[string]interface{}) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	return names
 }
 
 //	mock data
 
+// MockCategories are the categories of the mock data.
+var MockCategories = []string{"Books", "Electronics", "Garden", "Grocery", "Home", "Sports", "Toys", "Clothing"}
+
+// MockSize is the number of products of the mock data.
+const MockSize = 5000
+
 func mockData(db *gorm.DB) error {
 
 	var count int64
@@ -30,15 +108,33 @@ func mockData(db *gorm.DB) error {
 		return nil
 	}
 
+	categories := []Category{}
+	for _, name := range MockCategories {
+		category := Category{}
+		if err := db.Where(Category{Name: name}).FirstOrCreate(&category).Error; err != nil {
+			return err
+		}
+		categories = append(categories, category)
+	}
+
 	seed := rand.NewSource(time.Now().UnixNano())
-	random := rand.New(seed)
+	products := MockProducts(rand.New(seed), categories, MockSize)
+	return db.CreateInBatches(&products, 500).Error
+}
 
+// MockProducts returns size products like the mock data, not saved:
+// sequential names and skus, random quantities, prices and categories.
+func MockProducts(random *rand.Rand, categories []Category, size int) []ProductRow {
 	products := []product{}
-	for i := 0; i < 5000; i++ {
+	for i := 0; i < size; i++ {
+		category := categories[random.Intn(len(categories))]
 		products = append(products, product{
-			Name:     fmt.Sprintf("Product%v", i+1),
-			Quantity: random.Intn(100),
+			Name:       fmt.Sprintf("Product%v", i+1),
+			Quantity:   random.Intn(100),
+			Price:      int64(100 + random.Intn(99900)),
+			SKU:        fmt.Sprintf("SKU-%06d", i+1),
+			CategoryID: &category.ID,
 		})
 	}
-	return db.Create(&products).Error
+	return products
 }
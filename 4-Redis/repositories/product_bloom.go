@@ -0,0 +1,145 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"goredis/cache"
+	"goredis/metrics"
+	"log"
+	"strconv"
+
+	"github.com/go-redis/redis/v9"
+	"gorm.io/gorm"
+)
+
+//	keys
+
+// ProductBloomName names the Bloom filter of the product ids; its key adds
+// the filter sizes (see cache.NewBloom).
+const ProductBloomName = "bloom::products"
+
+// NewProductBloom sizes the filter of the product ids.
+func NewProductBloom(redisClient redis.UniversalClient, capacity int, falsePositiveRate float64) cache.Bloom {
+	return cache.NewBloom(redisClient, ProductBloomName, capacity, falsePositiveRate)
+}
+
+//	adapter
+
+// productRepositoryBloom answers ErrProductNotFound for ids the Bloom filter
+// of the products table has never seen, so crawlers probing random ids do
+// not reach the database. Deleted ids stay in the filter until a rebuild
+// (the database answers them), and a Redis failure lets the lookup through.
+// Ids created elsewhere (an instance without the filter) are only in it
+// after the next rebuild, see cache.bloom.rebuildInterval.
+type productRepositoryBloom struct {
+	productRepo ProductRepository
+	bloom       cache.Bloom
+}
+
+func NewProductRepositoryBloom(productRepo ProductRepository, bloom cache.Bloom) ProductRepository {
+	return productRepositoryBloom{productRepo: productRepo, bloom: bloom}
+}
+
+//	method
+
+func (r productRepositoryBloom) GetProducts(ctx context.Context, query ProductQuery) (productPage, error) {
+	return r.productRepo.GetProducts(ctx, query)
+}
+
+func (r productRepositoryBloom) GetProduct(ctx context.Context, id int) (product, error) {
+	if !r.mayExist(ctx, id) {
+		return product{}, ErrProductNotFound
+	}
+	return r.productRepo.GetProduct(ctx, id)
+}
+
+func (r productRepositoryBloom) GetProductRank(ctx context.Context, id int) (int64, error) {
+	if !r.mayExist(ctx, id) {
+		return 0, ErrProductNotFound
+	}
+	return r.productRepo.GetProductRank(ctx, id)
+}
+
+func (r productRepositoryBloom) mayExist(ctx context.Context, id int) bool {
+	maybe, err := r.bloom.MayContain(ctx, strconv.Itoa(id))
+	if err != nil {
+		r.observe(metrics.Error)
+		if !errors.Is(err, cache.ErrCircuitOpen) {
+			log.Println("bloom: test product:", err)
+		}
+		return true
+	}
+	if !maybe {
+		r.observe("rejected")
+		return false
+	}
+	r.observe("passed")
+	return true
+}
+
+func (r productRepositoryBloom) observe(result string) {
+	metrics.CacheRequests.WithLabelValues("bloom", ProductBloomName, result).Inc()
+}
+
+//	write (repository then filter)
+
+func (r productRepositoryBloom) CreateProduct(ctx context.Context, draft ProductDraft) (product, error) {
+	p, err := r.productRepo.CreateProduct(ctx, draft)
+	if err != nil {
+		return p, err
+	}
+	if err := r.bloom.Add(context.Background(), strconv.Itoa(p.ID)); err != nil {
+		log.Printf("bloom: add product %v: %v", p.ID, err)
+		// without the id the filter would reject the product until the next
+		// rebuild; without a filter every id goes to the database
+		if err := r.bloom.Drop(context.Background()); err != nil {
+			log.Println("bloom: drop:", err)
+		}
+	}
+	return p, nil
+}
+
+func (r productRepositoryBloom) UpdateProduct(ctx context.Context, id int, fields map[string]interface{}) (product, error) {
+	return r.productRepo.UpdateProduct(ctx, id, fields)
+}
+
+func (r productRepositoryBloom) DeleteProduct(ctx context.Context, id int) error {
+	return r.productRepo.DeleteProduct(ctx, id)
+}
+
+func (r productRepositoryBloom) DecrementQuantity(ctx context.Context, id int, quantity int) (product, error) {
+	return r.productRepo.DecrementQuantity(ctx, id, quantity)
+}
+
+func (r productRepositoryBloom) ApplyStockChanges(ctx context.Context, changes []stockChange) ([]product, error) {
+	return r.productRepo.ApplyStockChanges(ctx, changes)
+}
+
+//	rebuild
+
+// RebuildProductBloom fills the filter with every id of the products table.
+func RebuildProductBloom(ctx context.Context, db *gorm.DB, bloom cache.Bloom) (int, error) {
+	total := 0
+	err := bloom.Rebuild(ctx, func(add func(items ...string) error) error {
+		ids := []int{}
+		last := 0
+		for {
+			err := db.WithContext(ctx).Model(&product{}).
+				Where("id > ?", last).Order("id").Limit(1000).Pluck("id", &ids).Error
+			if err != nil || len(ids) == 0 {
+				return err
+			}
+			items := make([]string, len(ids))
+			for i, id := range ids {
+				items[i] = strconv.Itoa(id)
+			}
+			if err := add(items...); err != nil {
+				return err
+			}
+			total += len(ids)
+			last = ids[len(ids)-1]
+			ids = ids[:0]
+		}
+	})
+	return total, err
+}
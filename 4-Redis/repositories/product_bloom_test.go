@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// failScripts fails the lua scripts (Add and MayContain of the filter) while
+// on; the other commands go through.
+type failScripts struct {
+	on atomic.Bool
+}
+
+func (h *failScripts) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if h.on.Load() && strings.HasPrefix(cmd.Name(), "eval") {
+		return ctx, errors.New("script failed")
+	}
+	return ctx, nil
+}
+
+func (h *failScripts) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *failScripts) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *failScripts) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestProductBloomCreate(t *testing.T) {
+	tests := []struct {
+		name       string
+		failAdd    bool
+		wantFilter bool // still there, rejecting unknown ids
+	}{
+		{name: "added", wantFilter: true},
+		{name: "add fails", failAdd: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := newTestDB(t)
+			redisClient := newTestRedis(t, 1)[0]
+			hook := &failScripts{}
+			redisClient.AddHook(hook)
+
+			bloom := NewProductBloom(redisClient, 100000, 0.01)
+			productRepo := NewProductRepositoryBloom(NewProductRepositoryDB(ctx, db, nil), bloom)
+			if _, err := RebuildProductBloom(ctx, db, bloom); err != nil {
+				t.Fatal(err)
+			}
+
+			hook.on.Store(tt.failAdd)
+			p, err := productRepo.CreateProduct(ctx, ProductDraft{Name: "new", Quantity: 1, SKU: "NEW-1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			hook.on.Store(false)
+
+			if _, err := productRepo.GetProduct(ctx, p.ID); err != nil {
+				t.Fatalf("get the new product: %v", err)
+			}
+			if exists, _ := bloom.Exists(ctx); exists != tt.wantFilter {
+				t.Fatalf("filter exists = %v, want %v", exists, tt.wantFilter)
+			}
+		})
+	}
+}
@@ -1,6 +1,17 @@
 package repositories
 
-import "gorm.io/gorm"
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // 	adapter
 
@@ -8,15 +19,250 @@ type productRepositoryDB struct {
 	db *gorm.DB
 }
 
-func NewProductRepositoryDB(db *gorm.DB) ProductRepository {
-	db.AutoMigrate([]product{})
-	mockData(db)
+// NewProductRepositoryDB migrates the schema and fills an empty table with
+// mock data, then calls migrated (if not nil) for what needs the table. When
+// the database is down it returns anyway and retries in the background until
+// it answers or ctx is done.
+func NewProductRepositoryDB(ctx context.Context, db *gorm.DB, migrated func(ctx context.Context)) ProductRepository {
+	if migrated == nil {
+		migrated = func(context.Context) {}
+	}
+	err := prepare(db.WithContext(ctx))
+	if err == nil {
+		migrated(ctx)
+		return productRepositoryDB{db: db}
+	}
+
+	log.Println("products: migrate:", err)
+	retry := prepareRetry
+	go func() {
+		for prepare(db.WithContext(ctx)) != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+		}
+		log.Println("products: migrated")
+		migrated(ctx)
+	}()
 	return productRepositoryDB{db: db}
 }
 
 //	method
 
-func (r productRepositoryDB) GetProducts() (products []product, err error) {
-	err = r.db.Order("quantity desc").Limit(20).Find(&products).Error
+func (r productRepositoryDB) GetProducts(ctx context.Context, query ProductQuery) (page productPage, err error) {
+	query = query.Normalize()
+
+	err = r.db.WithContext(ctx).Model(&product{}).Scopes(query.filter).Count(&page.Total).Error
+	if err != nil {
+		return page, err
+	}
+	// an empty category, or one that does not exist
+	if page.Total == 0 && query.CategoryID != 0 {
+		if err := r.findCategory(ctx, r.db, query.CategoryID); err != nil {
+			return page, err
+		}
+	}
+
+	err = r.db.WithContext(ctx).Preload("Category").Scopes(query.filter, query.page).Find(&page.Products).Error
+	return page, err
+}
+
+func (r productRepositoryDB) GetProduct(ctx context.Context, id int) (p product, err error) {
+	return p, r.find(ctx, r.db, &p, id)
+}
+
+// find reads product id with its category into p, replacing what p held: a
+// category that was removed must not stay from an earlier read.
+func (r productRepositoryDB) find(ctx context.Context, db *gorm.DB, p *product, id int) error {
+	*p = product{}
+	err := db.WithContext(ctx).Preload("Category").First(p, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrProductNotFound
+	}
+	return err
+}
+
+func (r productRepositoryDB) GetProductRank(ctx context.Context, id int) (rank int64, err error) {
+	p, err := r.GetProduct(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	err = r.db.WithContext(ctx).Model(&product{}).
+		Where("quantity > ? OR (quantity = ? AND id < ?)", p.Quantity, p.Quantity, p.ID).
+		Count(&rank).Error
+	return rank + 1, err
+}
+
+func (r productRepositoryDB) CreateProduct(ctx context.Context, draft ProductDraft) (p product, err error) {
+	if draft.SKU == "" {
+		if draft.SKU, err = newSKU(); err != nil {
+			return p, err
+		}
+	}
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := r.checkProduct(ctx, tx, 0, draft.SKU, draft.CategoryID); err != nil {
+			return err
+		}
+		created := product{
+			Name:       draft.Name,
+			Quantity:   draft.Quantity,
+			Price:      draft.Price,
+			SKU:        draft.SKU,
+			CategoryID: draft.CategoryID,
+		}
+		if err := tx.WithContext(ctx).Create(&created).Error; err != nil {
+			return err
+		}
+		return r.find(ctx, tx, &p, created.ID)
+	})
+	return p, err
+}
+
+// UpdateProduct writes fields (columns; a nil category_id removes the
+// category) and returns the product as saved.
+func (r productRepositoryDB) UpdateProduct(ctx context.Context, id int, fields map[string]interface{}) (p product, err error) {
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := r.find(ctx, tx, &p, id); err != nil {
+			return err
+		}
+		if len(fields) == 0 {
+			return nil
+		}
+		sku, _ := fields["sku"].(string)
+		categoryID, _ := fields["category_id"].(*int)
+		if err := r.checkProduct(ctx, tx, id, sku, categoryID); err != nil {
+			return err
+		}
+		if err := tx.Model(&product{ID: id}).Updates(fields).Error; err != nil {
+			return err
+		}
+		return r.find(ctx, tx, &p, id)
+	})
+	return p, err
+}
+
+// checkProduct fails with ErrDuplicateSKU if another product than id has
+// sku, or ErrCategoryNotFound for an unknown category (the unique index and
+// the foreign key would only give driver errors).
+func (r productRepositoryDB) checkProduct(ctx context.Context, tx *gorm.DB, id int, sku string, categoryID *int) error {
+	if sku != "" {
+		var count int64
+		err := tx.WithContext(ctx).Model(&product{}).Where("sku = ? AND id <> ?", sku, id).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrDuplicateSKU
+		}
+	}
+	if categoryID != nil {
+		return r.findCategory(ctx, tx, *categoryID)
+	}
+	return nil
+}
+
+func (r productRepositoryDB) findCategory(ctx context.Context, db *gorm.DB, id int) error {
+	err := db.WithContext(ctx).First(&Category{}, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrCategoryNotFound
+	}
+	return err
+}
+
+// newSKU is a random SKU for a product created without one.
+func newSKU() (string, error) {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "SKU-" + strings.ToUpper(hex.EncodeToString(buf)), nil
+}
+
+func (r productRepositoryDB) DeleteProduct(ctx context.Context, id int) error {
+	result := r.db.WithContext(ctx).Delete(&product{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrProductNotFound
+	}
+	return nil
+}
+
+// DecrementQuantity is a single conditional UPDATE, so concurrent commits
+// cannot take the quantity below zero.
+func (r productRepositoryDB) DecrementQuantity(ctx context.Context, id int, quantity int) (p product, err error) {
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&product{}).
+			Where("id = ? AND quantity >= ?", id, quantity).
+			Update("quantity", gorm.Expr("quantity - ?", quantity))
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := r.find(ctx, tx, &p, id); err != nil {
+			return err
+		}
+		if result.RowsAffected == 0 {
+			return ErrInsufficientStock
+		}
+		return nil
+	})
+	return p, err
+}
+
+// ApplyStockChanges records the new changes in stock_changes and applies
+// their deltas in the same transaction, so a retried batch is a no-op.
+// Products are updated in id order to keep concurrent batches from
+// deadlocking each other.
+func (r productRepositoryDB) ApplyStockChanges(ctx context.Context, changes []stockChange) (products []product, err error) {
+	ids := make([]string, 0, len(changes))
+	for _, change := range changes {
+		ids = append(ids, change.ID)
+	}
+
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		applied := []string{}
+		if err := tx.Model(&stockChange{}).Where("id IN ?", ids).Pluck("id", &applied).Error; err != nil {
+			return err
+		}
+		skip := map[string]bool{}
+		for _, id := range applied {
+			skip[id] = true
+		}
+
+		fresh := []stockChange{}
+		deltas := map[int]int{}
+		for _, change := range changes {
+			if !skip[change.ID] {
+				skip[change.ID] = true
+				fresh = append(fresh, change)
+				deltas[change.ProductID] += change.Delta
+			}
+		}
+		if len(fresh) == 0 {
+			return nil
+		}
+		if err := tx.CreateInBatches(fresh, 500).Error; err != nil {
+			return err
+		}
+
+		productIDs := make([]int, 0, len(deltas))
+		for id := range deltas {
+			productIDs = append(productIDs, id)
+		}
+		sort.Ints(productIDs)
+		for _, id := range productIDs {
+			delta := deltas[id]
+			err := tx.Model(&product{}).Where("id = ?", id).
+				Update("quantity", gorm.Expr("CASE WHEN quantity + ? < 0 THEN 0 ELSE quantity + ? END", delta, delta)).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Preload("Category").Where("id IN ?", productIDs).Find(&products).Error
+	})
 	return products, err
 }
@@ -0,0 +1,86 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// newDownDB is a database whose statements fail until up is called (the
+// reads of a row excepted: the migrator does not expect them to fail).
+func newDownDB(t *testing.T) (db *gorm.DB, up func()) {
+	t.Helper()
+	db = newTestDB(t)
+	down := atomic.Bool{}
+	down.Store(true)
+	fail := func(db *gorm.DB) {
+		if down.Load() {
+			db.AddError(errors.New("database down"))
+		}
+	}
+	callbacks := db.Callback()
+	for _, err := range []error{
+		callbacks.Create().Before("gorm:create").Register("test:down", fail),
+		callbacks.Query().Before("gorm:query").Register("test:down", fail),
+		callbacks.Update().Before("gorm:update").Register("test:down", fail),
+		callbacks.Delete().Before("gorm:delete").Register("test:down", fail),
+		callbacks.Raw().Before("gorm:raw").Register("test:down", fail),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, func() { down.Store(false) }
+}
+
+func TestNewProductRepositoryDBRetries(t *testing.T) {
+	retry := prepareRetry
+	prepareRetry = 10 * time.Millisecond
+	t.Cleanup(func() { prepareRetry = retry })
+
+	tests := []struct {
+		name         string
+		cancel       bool // shut down before the database is up
+		wantMigrated bool
+	}{
+		{name: "database comes up", wantMigrated: true},
+		{name: "shut down first", cancel: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			db, up := newDownDB(t)
+			migrated := make(chan struct{})
+			productRepo := NewProductRepositoryDB(ctx, db, func(context.Context) { close(migrated) })
+
+			if tt.cancel {
+				cancel()
+				time.Sleep(5 * prepareRetry)
+			}
+			up()
+
+			if !tt.wantMigrated {
+				// a few retries later, still nothing
+				select {
+				case <-migrated:
+					t.Fatal("migrated after the shutdown")
+				case <-time.After(5 * prepareRetry):
+				}
+				return
+			}
+
+			// however long the migration and the mock data take here: a
+			// retry that never comes runs into the go test timeout
+			<-migrated
+			if _, err := productRepo.GetProduct(context.Background(), 1); err != nil {
+				t.Fatalf("get product after the migration: %v", err)
+			}
+		})
+	}
+}
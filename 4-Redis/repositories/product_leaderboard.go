@@ -0,0 +1,398 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"goredis/cache"
+	"goredis/metrics"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+	"gorm.io/gorm"
+)
+
+//	keys
+
+// Every key has the {leaderboard} hash tag: on a cluster the ZSET and the
+// hashes must share a slot for the scripts of a write and the RENAME of a
+// rebuild.
+const (
+	// LeaderboardKey is a ZSET of product ids scored by rankScore.
+	LeaderboardKey = "{leaderboard}::products"
+	// leaderboardProduct is the hash (leaderboardFields) of one product.
+	leaderboardProduct = "{leaderboard}::product"
+)
+
+// While a rebuild runs, the rebuilding key exists (with a TTL, in case the
+// rebuild dies), the new ZSET is built under building, and writes go to both
+// ZSETs and record their id in touched.
+var (
+	leaderboardRebuilding = cache.Key(LeaderboardKey, "rebuilding")
+	leaderboardBuilding   = cache.Key(LeaderboardKey, "rebuild")
+	leaderboardTouched    = cache.Key(LeaderboardKey, "rebuild", "touched")
+)
+
+// leaderboardRebuildTTL bounds a rebuild that stopped halfway; each batch
+// extends it.
+const leaderboardRebuildTTL = time.Minute
+
+// ErrLeaderboardRebuilding is returned by RebuildLeaderboard while another
+// rebuild runs.
+var ErrLeaderboardRebuilding = errors.New("leaderboard: rebuild already running")
+
+func leaderboardProductKey(id int) string {
+	return cache.Key(leaderboardProduct, strconv.Itoa(id))
+}
+
+// leaderboardFields are the fields of a product hash; times are unix nanos
+// and category_id is empty for a product without a category. A hash written
+// before a field existed misses it, and its pages are read from the database
+// until the next rebuild.
+var leaderboardFields = []string{"name", "quantity", "price", "sku", "category_id", "category", "created_at", "updated_at"}
+
+func leaderboardValues(p product) []interface{} {
+	categoryID, category := "", ""
+	if p.CategoryID != nil {
+		categoryID = strconv.Itoa(*p.CategoryID)
+	}
+	if p.Category != nil {
+		category = p.Category.Name
+	}
+	return []interface{}{
+		"name", p.Name,
+		"quantity", p.Quantity,
+		"price", p.Price,
+		"sku", p.SKU,
+		"category_id", categoryID,
+		"category", category,
+		"created_at", p.CreatedAt.UnixNano(),
+		"updated_at", p.UpdatedAt.UnixNano(),
+	}
+}
+
+// fromLeaderboard fills p from the values of leaderboardFields; ok is false
+// when one is missing.
+func fromLeaderboard(p *product, values []interface{}) (ok bool, err error) {
+	fields := make([]string, len(values))
+	for i, value := range values {
+		if fields[i], ok = value.(string); !ok {
+			return false, nil
+		}
+	}
+	p.Name, p.SKU = fields[0], fields[3]
+	if p.Quantity, err = strconv.Atoi(fields[1]); err != nil {
+		return false, err
+	}
+	if p.Price, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return false, err
+	}
+	if fields[4] != "" {
+		categoryID, err := strconv.Atoi(fields[4])
+		if err != nil {
+			return false, err
+		}
+		p.CategoryID = &categoryID
+		p.Category = &Category{ID: categoryID, Name: fields[5]}
+	}
+	for i, t := range []*time.Time{&p.CreatedAt, &p.UpdatedAt} {
+		nanos, err := strconv.ParseInt(fields[6+i], 10, 64)
+		if err != nil {
+			return false, err
+		}
+		*t = time.Unix(0, nanos)
+	}
+	return true, nil
+}
+
+// rankScore orders the ZSET like the default listing, quantity desc then id
+// asc, with a single ZREVRANGE. It is exact while quantity stays below ~9e6.
+func rankScore(p product) float64 {
+	return float64(p.Quantity)*1e9 - float64(p.ID)
+}
+
+//	adapter
+
+// productRepositoryLeaderboard serves the default listing (top products by
+// quantity) and ranks from a Redis ZSET that every write keeps up to date.
+// Other queries, and any Redis failure, go to the wrapped repository.
+type productRepositoryLeaderboard struct {
+	productRepo ProductRepository
+	redisClient redis.UniversalClient
+}
+
+func NewProductRepositoryLeaderboard(productRepo ProductRepository, redisClient redis.UniversalClient) ProductRepository {
+	return productRepositoryLeaderboard{productRepo: productRepo, redisClient: redisClient}
+}
+
+//	method
+
+func (r productRepositoryLeaderboard) GetProducts(ctx context.Context, query ProductQuery) (productPage, error) {
+	query = query.Normalize()
+	if !r.serves(query) {
+		return r.productRepo.GetProducts(ctx, query)
+	}
+
+	page, ok, err := r.top(ctx, query)
+	if err != nil {
+		log.Println("leaderboard: get products:", err)
+	}
+	if !ok {
+		r.observe(metrics.Miss)
+		return r.productRepo.GetProducts(ctx, query)
+	}
+	r.observe(metrics.Hit)
+	return page, nil
+}
+
+func (r productRepositoryLeaderboard) GetProduct(ctx context.Context, id int) (product, error) {
+	return r.productRepo.GetProduct(ctx, id)
+}
+
+func (r productRepositoryLeaderboard) GetProductRank(ctx context.Context, id int) (int64, error) {
+	rank, err := r.redisClient.ZRevRank(ctx, LeaderboardKey, strconv.Itoa(id)).Result()
+	if err != nil {
+		// not ranked (or not built yet): the database has the final word
+		if !errors.Is(err, redis.Nil) {
+			log.Println("leaderboard: get rank:", err)
+		}
+		r.observe(metrics.Miss)
+		return r.productRepo.GetProductRank(ctx, id)
+	}
+	r.observe(metrics.Hit)
+	return rank + 1, nil
+}
+
+// serves reports whether query is the listing the ZSET is ordered by.
+func (r productRepositoryLeaderboard) serves(query ProductQuery) bool {
+	return query.Sort == "quantity" && query.Order == "desc" && query.CategoryID == 0 &&
+		query.NamePrefix == "" && query.MinQuantity == nil && query.MaxQuantity == nil
+}
+
+// top reads one page from the ZSET; ok is false when the ZSET is missing or
+// does not match the hashes, so the caller reads the database instead.
+func (r productRepositoryLeaderboard) top(ctx context.Context, query ProductQuery) (page productPage, ok bool, err error) {
+	pipe := r.redisClient.Pipeline()
+	total := pipe.ZCard(ctx, LeaderboardKey)
+	ids := pipe.ZRevRange(ctx, LeaderboardKey, int64(query.Offset), int64(query.Offset+query.Limit-1))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return page, false, err
+	}
+	if total.Val() == 0 {
+		return page, false, nil
+	}
+
+	pipe = r.redisClient.Pipeline()
+	fields := make([]*redis.SliceCmd, 0, len(ids.Val()))
+	for _, member := range ids.Val() {
+		id, err := strconv.Atoi(member)
+		if err != nil {
+			return page, false, err
+		}
+		page.Products = append(page.Products, product{ID: id})
+		fields = append(fields, pipe.HMGet(ctx, leaderboardProductKey(id), leaderboardFields...))
+	}
+	if len(fields) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return page, false, err
+		}
+	}
+
+	for i, cmd := range fields {
+		if ok, err := fromLeaderboard(&page.Products[i], cmd.Val()); !ok {
+			return page, false, err
+		}
+	}
+	page.Total = total.Val()
+	return page, true, nil
+}
+
+func (r productRepositoryLeaderboard) observe(result string) {
+	metrics.CacheRequests.WithLabelValues("leaderboard", LeaderboardKey, result).Inc()
+}
+
+//	write (repository then leaderboard)
+
+func (r productRepositoryLeaderboard) CreateProduct(ctx context.Context, draft ProductDraft) (product, error) {
+	p, err := r.productRepo.CreateProduct(ctx, draft)
+	if err != nil {
+		return p, err
+	}
+	r.save(context.Background(), p)
+	return p, nil
+}
+
+func (r productRepositoryLeaderboard) UpdateProduct(ctx context.Context, id int, fields map[string]interface{}) (product, error) {
+	p, err := r.productRepo.UpdateProduct(ctx, id, fields)
+	if err != nil {
+		return p, err
+	}
+	r.save(context.Background(), p)
+	return p, nil
+}
+
+func (r productRepositoryLeaderboard) DeleteProduct(ctx context.Context, id int) error {
+	err := r.productRepo.DeleteProduct(ctx, id)
+	if err != nil {
+		return err
+	}
+	r.remove(context.Background(), id)
+	return nil
+}
+
+func (r productRepositoryLeaderboard) DecrementQuantity(ctx context.Context, id int, quantity int) (product, error) {
+	p, err := r.productRepo.DecrementQuantity(ctx, id, quantity)
+	if err != nil {
+		return p, err
+	}
+	r.save(context.Background(), p)
+	return p, nil
+}
+
+func (r productRepositoryLeaderboard) ApplyStockChanges(ctx context.Context, changes []stockChange) ([]product, error) {
+	products, err := r.productRepo.ApplyStockChanges(ctx, changes)
+	if err != nil {
+		return products, err
+	}
+	for _, p := range products {
+		r.save(context.Background(), p)
+	}
+	return products, nil
+}
+
+// leaderboardSaveScript writes the hash and the score of a product and,
+// during a rebuild, the score in the new ZSET too, marking the id touched so
+// the rebuild does not overwrite it with the row it read before the write.
+var leaderboardSaveScript = redis.NewScript(`
+redis.call("HSET", KEYS[2], unpack(ARGV, 3))
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+if redis.call("EXISTS", KEYS[3]) == 1 then
+	redis.call("ZADD", KEYS[4], ARGV[2], ARGV[1])
+	redis.call("SADD", KEYS[5], ARGV[1])
+end
+return 1
+`)
+
+// leaderboardRemoveScript is the same for a deleted product.
+var leaderboardRemoveScript = redis.NewScript(`
+redis.call("ZREM", KEYS[1], ARGV[1])
+redis.call("DEL", KEYS[2])
+if redis.call("EXISTS", KEYS[3]) == 1 then
+	redis.call("ZREM", KEYS[4], ARGV[1])
+	redis.call("SADD", KEYS[5], ARGV[1])
+end
+return 1
+`)
+
+func leaderboardWriteKeys(id int) []string {
+	return []string{LeaderboardKey, leaderboardProductKey(id), leaderboardRebuilding, leaderboardBuilding, leaderboardTouched}
+}
+
+// save and remove run after the write is committed, so like the cache
+// invalidation a failure is only logged; the rebuild command re-syncs.
+func (r productRepositoryLeaderboard) save(ctx context.Context, p product) {
+	args := append([]interface{}{p.ID, rankScore(p)}, leaderboardValues(p)...)
+	if err := leaderboardSaveScript.Run(ctx, r.redisClient, leaderboardWriteKeys(p.ID), args...).Err(); err != nil {
+		log.Println("leaderboard: save product:", err)
+	}
+}
+
+func (r productRepositoryLeaderboard) remove(ctx context.Context, id int) {
+	if err := leaderboardRemoveScript.Run(ctx, r.redisClient, leaderboardWriteKeys(id), id).Err(); err != nil {
+		log.Println("leaderboard: remove product:", err)
+	}
+}
+
+//	rebuild
+
+// leaderboardRebuildScript writes one batch of rows to the new ZSET and the
+// hashes, skipping the ids a write touched since the rebuild started: the
+// write saved a newer row than the batch holds. KEYS are the new ZSET, the
+// touched set and the hash of each product; ARGV the id, the score and the
+// leaderboardValues of each.
+var leaderboardRebuildScript = redis.NewScript(`
+local stride = 2 + ARGV[1]
+for i = 0, #KEYS - 3 do
+	local id = ARGV[2 + i * stride]
+	if redis.call("SISMEMBER", KEYS[2], id) == 0 then
+		redis.call("HSET", KEYS[3 + i], unpack(ARGV, 4 + i * stride, 1 + (i + 1) * stride))
+		redis.call("ZADD", KEYS[1], ARGV[3 + i * stride], id)
+	end
+end
+return 1
+`)
+
+// leaderboardSwapScript puts the new ZSET in place, or deletes the ranking
+// when there is nothing to rank, and ends the rebuild.
+var leaderboardSwapScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	redis.call("RENAME", KEYS[2], KEYS[1])
+else
+	redis.call("DEL", KEYS[1])
+end
+redis.call("DEL", KEYS[3], KEYS[4])
+return 1
+`)
+
+// RebuildLeaderboard re-syncs the leaderboard from the products table. The
+// new ZSET is built under a temporary key and swapped in with RENAME, so
+// readers never see a half-built ranking; writes made meanwhile go to both
+// ZSETs and win over the rows the rebuild read before them. Hashes of
+// products that no longer exist are removed afterwards. It returns the
+// number of products read, or ErrLeaderboardRebuilding when another rebuild
+// is running.
+func RebuildLeaderboard(ctx context.Context, db *gorm.DB, redisClient redis.UniversalClient) (int, error) {
+	started, err := redisClient.SetNX(ctx, leaderboardRebuilding, 1, leaderboardRebuildTTL).Result()
+	if err != nil {
+		return 0, err
+	}
+	if !started {
+		return 0, ErrLeaderboardRebuilding
+	}
+	if err := redisClient.Del(ctx, leaderboardBuilding, leaderboardTouched).Err(); err != nil {
+		redisClient.Del(ctx, leaderboardRebuilding)
+		return 0, err
+	}
+
+	total := 0
+	products := []product{}
+	err = db.WithContext(ctx).Preload("Category").Order("id").FindInBatches(&products, 500, func(tx *gorm.DB, batch int) error {
+		keys := []string{leaderboardBuilding, leaderboardTouched}
+		args := []interface{}{len(leaderboardFields) * 2}
+		for _, p := range products {
+			keys = append(keys, leaderboardProductKey(p.ID))
+			args = append(append(args, p.ID, rankScore(p)), leaderboardValues(p)...)
+		}
+		if err := leaderboardRebuildScript.Run(ctx, redisClient, keys, args...).Err(); err != nil {
+			return err
+		}
+		total += len(products)
+		return redisClient.Expire(ctx, leaderboardRebuilding, leaderboardRebuildTTL).Err()
+	}).Error
+	if err != nil {
+		redisClient.Del(ctx, leaderboardRebuilding, leaderboardBuilding, leaderboardTouched)
+		return 0, err
+	}
+
+	keys := []string{LeaderboardKey, leaderboardBuilding, leaderboardTouched, leaderboardRebuilding}
+	if err := leaderboardSwapScript.Run(ctx, redisClient, keys).Err(); err != nil {
+		return 0, err
+	}
+	return total, removeOrphans(ctx, redisClient)
+}
+
+// removeOrphans deletes product hashes whose id is no longer ranked.
+func removeOrphans(ctx context.Context, redisClient redis.UniversalClient) error {
+	return cache.ScanKeys(ctx, redisClient, cache.Key(leaderboardProduct, "*"), func(key string) error {
+		member := key[len(leaderboardProduct)+2:]
+		err := redisClient.ZScore(ctx, LeaderboardKey, member).Err()
+		switch {
+		case errors.Is(err, redis.Nil):
+			return redisClient.Del(ctx, key).Err()
+		case err != nil:
+			return err
+		}
+		return nil
+	})
+}
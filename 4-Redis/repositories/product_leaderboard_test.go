@@ -0,0 +1,169 @@
+package repositories
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-redis/redis/v9"
+	"gorm.io/gorm"
+)
+
+func TestRankScore(t *testing.T) {
+	products := []product{
+		{ID: 10, Quantity: 0},
+		{ID: 2, Quantity: 5},
+		{ID: 1, Quantity: 5},
+		{ID: 3, Quantity: 7},
+		{ID: 4000000, Quantity: 5},
+		{ID: 9, Quantity: 8000000},
+	}
+	sort.Slice(products, func(i, j int) bool { return rankScore(products[i]) > rankScore(products[j]) })
+
+	// quantity desc, then id asc, like the default listing
+	want := []int{9, 3, 1, 2, 4000000, 10}
+	for i, p := range products {
+		if p.ID != want[i] {
+			t.Fatalf("rank %d: product %d, want %d", i+1, p.ID, want[i])
+		}
+	}
+}
+
+// newTestLeaderboard returns the seeded database repository and the
+// leaderboard over it, built.
+func newTestLeaderboard(t *testing.T) (*gorm.DB, ProductRepository, ProductRepository, redis.UniversalClient) {
+	t.Helper()
+	db := newTestDB(t)
+	productRepo := NewProductRepositoryDB(context.Background(), db, nil)
+	redisClient := newTestRedis(t, 1)[0]
+	if _, err := RebuildLeaderboard(context.Background(), db, redisClient); err != nil {
+		t.Fatal(err)
+	}
+	return db, productRepo, NewProductRepositoryLeaderboard(productRepo, redisClient), redisClient
+}
+
+func pageIDs(page productPage) []int {
+	ids := []int{}
+	for _, p := range page.Products {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func TestLeaderboardTop(t *testing.T) {
+	ctx := context.Background()
+	_, productRepo, leaderboard, _ := newTestLeaderboard(t)
+
+	for _, query := range []ProductQuery{
+		{},
+		{Offset: 20},
+		{Limit: 100, Offset: 4950},
+	} {
+		want, err := productRepo.GetProducts(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := leaderboard.GetProducts(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Total != want.Total || !equalInts(pageIDs(got), pageIDs(want)) {
+			t.Fatalf("offset %d: %v of %d, want %v of %d", query.Offset, pageIDs(got), got.Total, pageIDs(want), want.Total)
+		}
+		for i := range got.Products {
+			if got.Products[i].Quantity != want.Products[i].Quantity || got.Products[i].SKU != want.Products[i].SKU {
+				t.Fatalf("product %d = %+v, want %+v", got.Products[i].ID, got.Products[i], want.Products[i])
+			}
+		}
+	}
+}
+
+func TestLeaderboardRank(t *testing.T) {
+	ctx := context.Background()
+	_, productRepo, leaderboard, _ := newTestLeaderboard(t)
+
+	top, err := productRepo.GetProducts(ctx, ProductQuery{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range top.Products {
+		rank, err := leaderboard.GetProductRank(ctx, p.ID)
+		if err != nil || rank != int64(i+1) {
+			t.Fatalf("rank of product %d = %d, %v, want %d", p.ID, rank, err, i+1)
+		}
+	}
+
+	// moved to the top by a write
+	last := top.Products[len(top.Products)-1].ID
+	if _, err := leaderboard.UpdateProduct(ctx, last, map[string]interface{}{"quantity": 1000}); err != nil {
+		t.Fatal(err)
+	}
+	if rank, err := leaderboard.GetProductRank(ctx, last); err != nil || rank != 1 {
+		t.Fatalf("rank after the update = %d, %v, want 1", rank, err)
+	}
+}
+
+func TestLeaderboardRebuildKeepsWrites(t *testing.T) {
+	const updated, deleted = 1, 2
+	ctx := context.Background()
+	db, _, leaderboard, redisClient := newTestLeaderboard(t)
+
+	// the writes land once the rebuild has read the first batch, before it
+	// writes it
+	armed := atomic.Bool{}
+	err := db.Callback().Query().After("gorm:query").Register("test:write", func(*gorm.DB) {
+		if !armed.CompareAndSwap(true, false) {
+			return
+		}
+		if _, err := leaderboard.UpdateProduct(ctx, updated, map[string]interface{}{"quantity": 1000}); err != nil {
+			t.Error(err)
+		}
+		if err := leaderboard.DeleteProduct(ctx, deleted); err != nil {
+			t.Error(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	armed.Store(true)
+	if _, err := RebuildLeaderboard(ctx, db, redisClient); err != nil {
+		t.Fatal(err)
+	}
+	if armed.Load() {
+		t.Fatal("no write during the rebuild")
+	}
+
+	if rank, err := leaderboard.GetProductRank(ctx, updated); err != nil || rank != 1 {
+		t.Fatalf("rank of the updated product = %d, %v, want 1", rank, err)
+	}
+	if err := redisClient.ZScore(ctx, LeaderboardKey, "2").Err(); err != redis.Nil {
+		t.Fatalf("deleted product still ranked: %v", err)
+	}
+	if n, _ := redisClient.Exists(ctx, leaderboardProductKey(deleted), leaderboardRebuilding, leaderboardBuilding, leaderboardTouched).Result(); n != 0 {
+		t.Fatalf("%d keys left of the deleted product and the rebuild", n)
+	}
+}
+
+func TestLeaderboardRebuildRunning(t *testing.T) {
+	ctx := context.Background()
+	db, _, _, redisClient := newTestLeaderboard(t)
+	if err := redisClient.Set(ctx, leaderboardRebuilding, 1, 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RebuildLeaderboard(ctx, db, redisClient); err != ErrLeaderboardRebuilding {
+		t.Fatalf("err = %v, want ErrLeaderboardRebuilding", err)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
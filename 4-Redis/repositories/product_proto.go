@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"goredis/cache"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+//	protobuf (schema : proto/catalog.proto, Product and RepositoryPage)
+
+func (p *product) MarshalProto() ([]byte, error) {
+	var buf []byte
+	buf = cache.AppendProtoVarint(buf, 1, int64(p.ID))
+	buf = cache.AppendProtoBytes(buf, 2, []byte(p.Name))
+	buf = cache.AppendProtoVarint(buf, 3, int64(p.Quantity))
+	buf = cache.AppendProtoVarint(buf, 4, p.Price)
+	buf = cache.AppendProtoBytes(buf, 5, []byte(p.SKU))
+	if p.Category != nil {
+		var category []byte
+		category = cache.AppendProtoVarint(category, 1, int64(p.Category.ID))
+		category = cache.AppendProtoBytes(category, 2, []byte(p.Category.Name))
+		buf = cache.AppendProtoBytes(buf, 6, category)
+	}
+	buf = cache.AppendProtoVarint(buf, 7, p.CreatedAt.UnixNano())
+	return cache.AppendProtoVarint(buf, 8, p.UpdatedAt.UnixNano()), nil
+}
+
+func (p *product) UnmarshalProto(data []byte) error {
+	*p = product{}
+	return cache.ConsumeProtoFields(data, func(num protowire.Number, value uint64, bytes []byte) error {
+		switch num {
+		case 1:
+			p.ID = int(value)
+		case 2:
+			p.Name = string(bytes)
+		case 3:
+			p.Quantity = int(value)
+		case 4:
+			p.Price = int64(value)
+		case 5:
+			p.SKU = string(bytes)
+		case 6:
+			p.Category = &Category{}
+			return cache.ConsumeProtoFields(bytes, func(num protowire.Number, value uint64, bytes []byte) error {
+				switch num {
+				case 1:
+					p.Category.ID = int(value)
+					p.CategoryID = &p.Category.ID
+				case 2:
+					p.Category.Name = string(bytes)
+				}
+				return nil
+			})
+		case 7:
+			p.CreatedAt = time.Unix(0, int64(value)).UTC()
+		case 8:
+			p.UpdatedAt = time.Unix(0, int64(value)).UTC()
+		}
+		return nil
+	})
+}
+
+func (p *productPage) MarshalProto() ([]byte, error) {
+	var buf []byte
+	for _, product := range p.Products {
+		item, err := product.MarshalProto()
+		if err != nil {
+			return nil, err
+		}
+		buf = cache.AppendProtoBytes(buf, 1, item)
+	}
+	return cache.AppendProtoVarint(buf, 2, p.Total), nil
+}
+
+func (p *productPage) UnmarshalProto(data []byte) error {
+	*p = productPage{Products: []product{}}
+	return cache.ConsumeProtoFields(data, func(num protowire.Number, value uint64, bytes []byte) error {
+		switch num {
+		case 1:
+			item := product{}
+			err := item.UnmarshalProto(bytes)
+			p.Products = append(p.Products, item)
+			return err
+		case 2:
+			p.Total = int64(value)
+		}
+		return nil
+	})
+}
@@ -2,59 +2,125 @@ package repositories
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"time"
+	"goredis/cache"
 
 	"github.com/go-redis/redis/v9"
-	"gorm.io/gorm"
 )
 
 //	adapter
 
 type productRepositoryRedis struct {
-	db          *gorm.DB
-	redisClient *redis.Client
+	productRepo ProductRepository
+	redisClient redis.UniversalClient
+	products    cache.Aside[ProductQuery, productPage]
+	product     cache.Aside[int, product]
 }
 
-func NewProductRepositoryRedis(db *gorm.DB, redisClient *redis.Client) ProductRepository {
-	db.AutoMigrate([]product{})
-	mockData(db)
-	return productRepositoryRedis{db: db, redisClient: redisClient}
+func NewProductRepositoryRedis(productRepo ProductRepository, redisClient redis.UniversalClient, settings cache.Settings) ProductRepository {
+	return productRepositoryRedis{
+		productRepo: productRepo,
+		redisClient: redisClient,
+		products: cache.NewAside(redisClient, cache.Options[ProductQuery, productPage]{
+			Name:   "repository",
+			Family: cache.RepositoryGetProducts,
+			Key: func(query ProductQuery) string {
+				return cache.Key(cache.RepositoryGetProducts, query.CacheKey())
+			},
+			TTL:          settings.TTL,
+			Stale:        settings.Stale,
+			EarlyRefresh: settings.EarlyRefresh,
+			Local:        settings.Local,
+			Codec:        cache.NewCodec[productPage](settings.Format),
+			Tags: func(query ProductQuery, page productPage) []string {
+				return append(query.CacheTags(), cache.ProductTags(productIDs(page.Products)...)...)
+			},
+		}),
+		product: cache.NewAside(redisClient, cache.Options[int, product]{
+			Name:   "repository",
+			Family: cache.RepositoryGetProduct,
+			Key: func(id int) string {
+				return cache.ProductKey(cache.RepositoryGetProduct, id)
+			},
+			TTL:          settings.TTL,
+			Stale:        settings.Stale,
+			EarlyRefresh: settings.EarlyRefresh,
+			Local:        settings.Local,
+			Codec:        cache.NewCodec[product](settings.Format),
+			Missing:      ErrProductNotFound,
+			MissingTTL:   settings.MissingTTL,
+			Tags: func(id int, _ product) []string {
+				return []string{cache.ProductTag(id)}
+			},
+		}),
+	}
 }
 
 //	method
 
-func (r productRepositoryRedis) GetProducts() (products []product, err error) {
+func (r productRepositoryRedis) GetProducts(ctx context.Context, query ProductQuery) (productPage, error) {
+	return r.products.Get(ctx, query.Normalize(), r.productRepo.GetProducts)
+}
 
-	key := "repository::GetProducts"
+func (r productRepositoryRedis) GetProduct(ctx context.Context, id int) (product, error) {
+	return r.product.Get(ctx, id, r.productRepo.GetProduct)
+}
+
+// WarmProducts loads a listing into the cache ahead of the requests.
+func (r productRepositoryRedis) WarmProducts(ctx context.Context, query ProductQuery) error {
+	return r.products.Warm(ctx, query.Normalize(), r.productRepo.GetProducts)
+}
 
-	//	redis get
-	productsJson, err := r.redisClient.Get(context.Background(), key).Result()
-	if err == nil {
-		json.Unmarshal([]byte(productsJson), &products)
-		if err == nil {
-			fmt.Println("redis")
-			return products, nil
-		}
+// ranks change with every write, they are not cached
+func (r productRepositoryRedis) GetProductRank(ctx context.Context, id int) (int64, error) {
+	return r.productRepo.GetProductRank(ctx, id)
+}
+
+//	write (repository then invalidate)
+
+func (r productRepositoryRedis) CreateProduct(ctx context.Context, draft ProductDraft) (product, error) {
+	p, err := r.productRepo.CreateProduct(ctx, draft)
+	if err != nil {
+		return p, err
 	}
+	// the id may have been looked up (and cached as missing) before
+	cache.InvalidateProducts(context.Background(), r.redisClient, p.ID)
+	return p, nil
+}
+
+func (r productRepositoryRedis) UpdateProduct(ctx context.Context, id int, fields map[string]interface{}) (product, error) {
+	p, err := r.productRepo.UpdateProduct(ctx, id, fields)
+	if err != nil {
+		return p, err
+	}
+	cache.InvalidateProductFields(context.Background(), r.redisClient, fieldNames(fields), id)
+	return p, nil
+}
 
-	//	database
-	err = r.db.Order("quantity desc").Limit(20).Find(&products).Error
+func (r productRepositoryRedis) DeleteProduct(ctx context.Context, id int) error {
+	err := r.productRepo.DeleteProduct(ctx, id)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	cache.InvalidateProducts(context.Background(), r.redisClient, id)
+	return nil
+}
 
-	//	redis set
-	data, err := json.Marshal(products)
+func (r productRepositoryRedis) DecrementQuantity(ctx context.Context, id int, quantity int) (product, error) {
+	p, err := r.productRepo.DecrementQuantity(ctx, id, quantity)
 	if err != nil {
-		return nil, err
+		return p, err
 	}
+	cache.InvalidateProductFields(context.Background(), r.redisClient, []string{"quantity"}, id)
+	return p, nil
+}
 
-	err = r.redisClient.Set(context.Background(), key, string(data), time.Second*10).Err()
+func (r productRepositoryRedis) ApplyStockChanges(ctx context.Context, changes []stockChange) ([]product, error) {
+	products, err := r.productRepo.ApplyStockChanges(ctx, changes)
 	if err != nil {
-		return nil, err
+		return products, err
+	}
+	if len(products) > 0 {
+		cache.InvalidateProductFields(context.Background(), r.redisClient, []string{"quantity"}, productIDs(products)...)
 	}
-	fmt.Println("database")
 	return products, nil
 }
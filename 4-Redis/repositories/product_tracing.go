@@ -0,0 +1,83 @@
+package repositories
+
+import (
+	"context"
+	"goredis/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//	adapter
+
+// productRepositoryTracing records a span per ProductRepository call, so a
+// trace shows the time spent under the service: the cache decorators below
+// it, their Redis commands and the GORM queries are its children.
+type productRepositoryTracing struct {
+	productRepo ProductRepository
+}
+
+func NewProductRepositoryTracing(productRepo ProductRepository) ProductRepository {
+	return productRepositoryTracing{productRepo: productRepo}
+}
+
+//	method
+
+func (r productRepositoryTracing) GetProducts(ctx context.Context, query ProductQuery) (page productPage, err error) {
+	ctx, span := tracing.Start(ctx, "ProductRepository.GetProducts", attribute.String("catalog.query", query.CacheKey()))
+	defer func() { tracing.End(span, err, ErrCategoryNotFound) }()
+
+	page, err = r.productRepo.GetProducts(ctx, query)
+	span.SetAttributes(attribute.Int("catalog.products", len(page.Products)))
+	return page, err
+}
+
+func (r productRepositoryTracing) GetProduct(ctx context.Context, id int) (p product, err error) {
+	ctx, span := tracing.Start(ctx, "ProductRepository.GetProduct", attribute.Int("product.id", id))
+	defer func() { tracing.End(span, err, ErrProductNotFound) }()
+	return r.productRepo.GetProduct(ctx, id)
+}
+
+func (r productRepositoryTracing) GetProductRank(ctx context.Context, id int) (rank int64, err error) {
+	ctx, span := tracing.Start(ctx, "ProductRepository.GetProductRank", attribute.Int("product.id", id))
+	defer func() { tracing.End(span, err, ErrProductNotFound) }()
+	return r.productRepo.GetProductRank(ctx, id)
+}
+
+//	write
+
+func (r productRepositoryTracing) CreateProduct(ctx context.Context, draft ProductDraft) (p product, err error) {
+	ctx, span := tracing.Start(ctx, "ProductRepository.CreateProduct")
+	defer func() { tracing.End(span, err, ErrCategoryNotFound, ErrDuplicateSKU) }()
+
+	p, err = r.productRepo.CreateProduct(ctx, draft)
+	span.SetAttributes(attribute.Int("product.id", p.ID))
+	return p, err
+}
+
+func (r productRepositoryTracing) UpdateProduct(ctx context.Context, id int, fields map[string]interface{}) (p product, err error) {
+	ctx, span := tracing.Start(ctx, "ProductRepository.UpdateProduct",
+		attribute.Int("product.id", id),
+		attribute.StringSlice("product.fields", fieldNames(fields)))
+	defer func() { tracing.End(span, err, ErrProductNotFound, ErrCategoryNotFound, ErrDuplicateSKU) }()
+	return r.productRepo.UpdateProduct(ctx, id, fields)
+}
+
+func (r productRepositoryTracing) DeleteProduct(ctx context.Context, id int) (err error) {
+	ctx, span := tracing.Start(ctx, "ProductRepository.DeleteProduct", attribute.Int("product.id", id))
+	defer func() { tracing.End(span, err, ErrProductNotFound) }()
+	return r.productRepo.DeleteProduct(ctx, id)
+}
+
+func (r productRepositoryTracing) DecrementQuantity(ctx context.Context, id int, quantity int) (p product, err error) {
+	ctx, span := tracing.Start(ctx, "ProductRepository.DecrementQuantity",
+		attribute.Int("product.id", id),
+		attribute.Int("product.quantity", quantity))
+	defer func() { tracing.End(span, err, ErrProductNotFound, ErrInsufficientStock) }()
+	return r.productRepo.DecrementQuantity(ctx, id, quantity)
+}
+
+func (r productRepositoryTracing) ApplyStockChanges(ctx context.Context, changes []stockChange) (products []product, err error) {
+	ctx, span := tracing.Start(ctx, "ProductRepository.ApplyStockChanges", attribute.Int("stock.changes", len(changes)))
+	defer func() { tracing.End(span, err) }()
+	return r.productRepo.ApplyStockChanges(ctx, changes)
+}
@@ -0,0 +1,138 @@
+package repositories
+
+import (
+	"goredis/cache"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// ProductQuery describes one page of the product listing. The zero value is
+// the original listing: top 20 products by quantity.
+type ProductQuery struct {
+	Limit       int
+	Offset      int
+	Sort        string // id | name | quantity | price
+	Order       string // asc | desc
+	NamePrefix  string
+	MinQuantity *int
+	MaxQuantity *int
+	CategoryID  int // 0 = every category
+}
+
+var sortColumns = map[string]bool{"id": true, "name": true, "quantity": true, "price": true}
+
+// Normalize fills defaults and clamps values, so two queries that return the
+// same page also compare (and hash) equal.
+func (q ProductQuery) Normalize() ProductQuery {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	q.Sort = strings.ToLower(q.Sort)
+	if !sortColumns[q.Sort] {
+		q.Sort = "quantity"
+	}
+	q.Order = strings.ToLower(q.Order)
+	if q.Order != "asc" {
+		q.Order = "desc"
+	}
+	if q.MinQuantity != nil && q.MaxQuantity != nil && *q.MinQuantity > *q.MaxQuantity {
+		q.MinQuantity, q.MaxQuantity = q.MaxQuantity, q.MinQuantity
+	}
+	if q.CategoryID < 0 {
+		q.CategoryID = 0
+	}
+	return q
+}
+
+// CacheKey is the canonical form of a normalized query, used as the suffix
+// of every cached listing key. A category listing is scoped by its category,
+// e.g. "category=7::limit=20&offset=0&order=desc&sort=quantity".
+func (q ProductQuery) CacheKey() string {
+	q = q.Normalize()
+	values := url.Values{}
+	values.Set("limit", strconv.Itoa(q.Limit))
+	values.Set("offset", strconv.Itoa(q.Offset))
+	values.Set("sort", q.Sort)
+	values.Set("order", q.Order)
+	if q.NamePrefix != "" {
+		values.Set("name", q.NamePrefix)
+	}
+	if q.MinQuantity != nil {
+		values.Set("min", strconv.Itoa(*q.MinQuantity))
+	}
+	if q.MaxQuantity != nil {
+		values.Set("max", strconv.Itoa(*q.MaxQuantity))
+	}
+	if q.CategoryID != 0 {
+		return cache.Key("category="+strconv.Itoa(q.CategoryID), values.Encode())
+	}
+	return values.Encode()
+}
+
+// CacheTags are the tags of a cached listing page, besides those of the
+// products on it: cache.ListTag, cache.ListFieldTag of each field its filter
+// or order reads, and the cache.CategoryTag of a category listing.
+func (q ProductQuery) CacheTags() []string {
+	q = q.Normalize()
+	tags := []string{cache.ListTag}
+	if q.Sort != "id" {
+		tags = append(tags, cache.ListFieldTag(q.Sort))
+	}
+	if q.NamePrefix != "" && q.Sort != "name" {
+		tags = append(tags, cache.ListFieldTag("name"))
+	}
+	if (q.MinQuantity != nil || q.MaxQuantity != nil) && q.Sort != "quantity" {
+		tags = append(tags, cache.ListFieldTag("quantity"))
+	}
+	if q.CategoryID != 0 {
+		tags = append(tags, cache.ListFieldTag("category_id"), cache.CategoryTag(q.CategoryID))
+	}
+	return tags
+}
+
+//	gorm scopes
+
+func (q ProductQuery) filter(db *gorm.DB) *gorm.DB {
+	if q.NamePrefix != "" {
+		db = db.Where("name LIKE ? ESCAPE '!'", likePrefix(q.NamePrefix))
+	}
+	if q.MinQuantity != nil {
+		db = db.Where("quantity >= ?", *q.MinQuantity)
+	}
+	if q.MaxQuantity != nil {
+		db = db.Where("quantity <= ?", *q.MaxQuantity)
+	}
+	if q.CategoryID != 0 {
+		db = db.Where("category_id = ?", q.CategoryID)
+	}
+	return db
+}
+
+func (q ProductQuery) page(db *gorm.DB) *gorm.DB {
+	order := q.Sort + " " + q.Order
+	if q.Sort != "id" {
+		order += ", id asc"
+	}
+	return db.Order(order).Limit(q.Limit).Offset(q.Offset)
+}
+
+// likePrefix escapes LIKE wildcards with '!', which means the same thing to
+// MariaDB and SQLite (a backslash does not).
+func likePrefix(prefix string) string {
+	replacer := strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+	return replacer.Replace(prefix) + "%"
+}
@@ -0,0 +1,133 @@
+package repositories
+
+import (
+	"goredis/cache"
+	"reflect"
+	"testing"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestProductQueryNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		query ProductQuery
+		want  ProductQuery
+	}{
+		{
+			name:  "zero value is the default listing",
+			query: ProductQuery{},
+			want:  ProductQuery{Limit: DefaultLimit, Sort: "quantity", Order: "desc"},
+		},
+		{
+			name:  "limit is clamped",
+			query: ProductQuery{Limit: 1000, Offset: -5},
+			want:  ProductQuery{Limit: MaxLimit, Sort: "quantity", Order: "desc"},
+		},
+		{
+			name:  "sort and order are case insensitive",
+			query: ProductQuery{Limit: 10, Sort: "NAME", Order: "ASC"},
+			want:  ProductQuery{Limit: 10, Sort: "name", Order: "asc"},
+		},
+		{
+			name:  "unknown sort and order",
+			query: ProductQuery{Sort: "password", Order: "sideways"},
+			want:  ProductQuery{Limit: DefaultLimit, Sort: "quantity", Order: "desc"},
+		},
+		{
+			name:  "quantity bounds are swapped",
+			query: ProductQuery{MinQuantity: intPtr(50), MaxQuantity: intPtr(10)},
+			want:  ProductQuery{Limit: DefaultLimit, Sort: "quantity", Order: "desc", MinQuantity: intPtr(10), MaxQuantity: intPtr(50)},
+		},
+		{
+			name:  "negative category is every category",
+			query: ProductQuery{CategoryID: -3},
+			want:  ProductQuery{Limit: DefaultLimit, Sort: "quantity", Order: "desc"},
+		},
+		{
+			name:  "normalized is kept",
+			query: ProductQuery{Limit: 5, Offset: 40, Sort: "price", Order: "asc", NamePrefix: "Prod", CategoryID: 7},
+			want:  ProductQuery{Limit: 5, Offset: 40, Sort: "price", Order: "asc", NamePrefix: "Prod", CategoryID: 7},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.query.Normalize()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Normalize() = %+v, want %+v", got, tt.want)
+			}
+			if again := got.Normalize(); !reflect.DeepEqual(again, got) {
+				t.Fatalf("Normalize() is not idempotent: %+v", again)
+			}
+		})
+	}
+}
+
+func TestProductQueryCacheKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		query ProductQuery
+		want  string
+	}{
+		{name: "default", query: ProductQuery{}, want: "limit=20&offset=0&order=desc&sort=quantity"},
+		{name: "same page, other spelling", query: ProductQuery{Limit: 20, Sort: "Quantity", Order: "DESC"}, want: "limit=20&offset=0&order=desc&sort=quantity"},
+		{name: "filters", query: ProductQuery{NamePrefix: "a&b", MinQuantity: intPtr(1), MaxQuantity: intPtr(9)}, want: "limit=20&max=9&min=1&name=a%26b&offset=0&order=desc&sort=quantity"},
+		{name: "swapped bounds", query: ProductQuery{MinQuantity: intPtr(9), MaxQuantity: intPtr(1)}, want: "limit=20&max=9&min=1&offset=0&order=desc&sort=quantity"},
+		{name: "category", query: ProductQuery{CategoryID: 7, Sort: "price"}, want: "category=7::limit=20&offset=0&order=desc&sort=price"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.query.CacheKey(); got != tt.want {
+				t.Fatalf("CacheKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProductQueryCacheTags(t *testing.T) {
+	tests := []struct {
+		name  string
+		query ProductQuery
+		want  []string
+	}{
+		{name: "default", query: ProductQuery{}, want: []string{cache.ListTag, cache.ListFieldTag("quantity")}},
+		{name: "by id", query: ProductQuery{Sort: "id"}, want: []string{cache.ListTag}},
+		{name: "name filter", query: ProductQuery{Sort: "id", NamePrefix: "P"}, want: []string{cache.ListTag, cache.ListFieldTag("name")}},
+		{name: "name filter sorted by name", query: ProductQuery{Sort: "name", NamePrefix: "P"}, want: []string{cache.ListTag, cache.ListFieldTag("name")}},
+		{name: "quantity filter", query: ProductQuery{Sort: "price", MinQuantity: intPtr(1)}, want: []string{cache.ListTag, cache.ListFieldTag("price"), cache.ListFieldTag("quantity")}},
+		{
+			name:  "category",
+			query: ProductQuery{CategoryID: 7},
+			want:  []string{cache.ListTag, cache.ListFieldTag("quantity"), cache.ListFieldTag("category_id"), cache.CategoryTag(7)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.query.CacheTags(); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("CacheTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLikePrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{prefix: "Product", want: "Product%"},
+		{prefix: "50%_off!", want: "50!%!_off!!%"},
+		{prefix: "", want: "%"},
+	}
+
+	for _, tt := range tests {
+		if got := likePrefix(tt.prefix); got != tt.want {
+			t.Fatalf("likePrefix(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type reservation struct {
+	ID        string
+	ProductID int
+	Quantity  int
+	// Available is what is left to reserve after this hold.
+	Available int64
+	ExpiresAt time.Time
+}
+
+// 	port
+
+// StockRepository is a ProductRepository that can also hold stock for a
+// while: a reservation takes quantity out of what others can reserve until it
+// is released, committed (persisted as a DecrementQuantity) or expires.
+type StockRepository interface {
+	ProductRepository
+	ReserveStock(ctx context.Context, id int, quantity int, ttl time.Duration) (reservation, error)
+	ReleaseStock(ctx context.Context, id int, reservationID string) error
+	CommitStock(ctx context.Context, id int, reservationID string) (product, error)
+}
+
+var ErrReservationNotFound = errors.New("reservation not found")
@@ -0,0 +1,23 @@
+package repositories
+
+import (
+	"context"
+	"time"
+)
+
+// stockChange is one write-behind quantity change. The ID is the id of its
+// stream entry, so the stock_changes table doubles as the ledger of what has
+// been applied: a batch delivered twice is only applied once.
+type stockChange struct {
+	ID        string `gorm:"primaryKey;size:64"`
+	ProductID int    `gorm:"index"`
+	Delta     int
+	CreatedAt time.Time
+}
+
+// 	port
+
+// StockChangeQueue takes quantity changes now and persists them later.
+type StockChangeQueue interface {
+	EnqueueStockChange(ctx context.Context, id int, delta int) (string, error)
+}
@@ -0,0 +1,290 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goredis/metrics"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+//	stream
+
+// StockChangeStream holds one entry (product, delta) per queued change until
+// a writer has persisted it; flushed entries are acknowledged and deleted.
+const StockChangeStream = "stock::changes"
+
+//	queue adapter
+
+type stockChangeQueueRedis struct {
+	redisClient redis.UniversalClient
+}
+
+func NewStockChangeQueueRedis(redisClient redis.UniversalClient) StockChangeQueue {
+	return stockChangeQueueRedis{redisClient: redisClient}
+}
+
+func (q stockChangeQueueRedis) EnqueueStockChange(ctx context.Context, id int, delta int) (string, error) {
+	return q.redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: StockChangeStream,
+		Values: []interface{}{"product", id, "delta", delta},
+	}).Result()
+}
+
+//	writer
+
+type StockChangeWriterOptions struct {
+	// Group is the consumer group shared by every instance; Consumer names
+	// this one (defaults to host-pid).
+	Group    string
+	Consumer string
+	// BatchSize entries are read, and applied in one transaction, at a time.
+	// A read waits up to FlushInterval for entries.
+	BatchSize     int
+	FlushInterval time.Duration
+	// Retries is how often a failed batch is retried right away; after that
+	// it stays pending until the reaper claims it, once idle for ClaimIdle.
+	Retries   int
+	ClaimIdle time.Duration
+	// DrainTimeout bounds the flush of what is left when Run stops.
+	DrainTimeout time.Duration
+}
+
+// StockChangeWriter is the write-behind worker: it reads the stream through
+// a consumer group and applies the changes with ApplyStockChanges. Every
+// instance can run one; entries left pending by a crashed instance are
+// claimed by the others.
+type StockChangeWriter struct {
+	productRepo ProductRepository
+	redisClient redis.UniversalClient
+	options     StockChangeWriterOptions
+}
+
+func NewStockChangeWriter(productRepo ProductRepository, redisClient redis.UniversalClient, options StockChangeWriterOptions) StockChangeWriter {
+	if options.Group == "" {
+		options.Group = "writers"
+	}
+	if options.Consumer == "" {
+		host, _ := os.Hostname()
+		options.Consumer = fmt.Sprintf("%v-%v", host, os.Getpid())
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 500
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = time.Second
+	}
+	if options.Retries <= 0 {
+		options.Retries = 3
+	}
+	if options.ClaimIdle <= 0 {
+		options.ClaimIdle = 30 * time.Second
+	}
+	if options.DrainTimeout <= 0 {
+		options.DrainTimeout = 10 * time.Second
+	}
+	return StockChangeWriter{productRepo: productRepo, redisClient: redisClient, options: options}
+}
+
+// Run flushes the stream until ctx is done, then drains what is left (new
+// entries and this consumer's pending ones) before returning.
+func (w StockChangeWriter) Run(ctx context.Context) {
+
+	// 	pending entries of a previous run of this consumer
+	w.flushAll(ctx, "0")
+
+	reaper := time.NewTicker(w.options.ClaimIdle / 2)
+	defer reaper.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.drain()
+			return
+		case <-reaper.C:
+			w.reap(ctx)
+		default:
+		}
+
+		messages, err := w.read(ctx, ">", w.options.FlushInterval)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("stock changes: read:", err)
+				w.sleep(ctx, w.options.FlushInterval)
+			}
+			continue
+		}
+		if !w.flush(ctx, messages) {
+			w.sleep(ctx, w.options.FlushInterval)
+		}
+	}
+}
+
+func (w StockChangeWriter) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), w.options.DrainTimeout)
+	defer cancel()
+	w.flushAll(ctx, ">")
+	w.flushAll(ctx, "0")
+}
+
+// flushAll flushes batches without waiting until there is nothing left to
+// read from id (">" new entries, "0" this consumer's pending entries).
+func (w StockChangeWriter) flushAll(ctx context.Context, id string) {
+	for ctx.Err() == nil {
+		messages, err := w.read(ctx, id, -1)
+		if err != nil {
+			log.Println("stock changes: read:", err)
+			return
+		}
+		if len(messages) == 0 || !w.flush(ctx, messages) {
+			return
+		}
+	}
+}
+
+// reap claims the entries other consumers (or this one) left pending for
+// longer than ClaimIdle and flushes them.
+func (w StockChangeWriter) reap(ctx context.Context) {
+	start := "0-0"
+	for ctx.Err() == nil {
+		messages, next, err := w.redisClient.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   StockChangeStream,
+			Group:    w.options.Group,
+			Consumer: w.options.Consumer,
+			MinIdle:  w.options.ClaimIdle,
+			Start:    start,
+			Count:    int64(w.options.BatchSize),
+		}).Result()
+		if err != nil {
+			if !w.missingGroup(ctx, err) {
+				log.Println("stock changes: claim:", err)
+			}
+			return
+		}
+		if len(messages) > 0 {
+			metrics.StockChanges.WithLabelValues("claimed").Add(float64(len(messages)))
+			if !w.flush(ctx, messages) {
+				return
+			}
+		}
+		if next == "0-0" {
+			return
+		}
+		start = next
+	}
+}
+
+func (w StockChangeWriter) read(ctx context.Context, id string, block time.Duration) ([]redis.XMessage, error) {
+	streams, err := w.redisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    w.options.Group,
+		Consumer: w.options.Consumer,
+		Streams:  []string{StockChangeStream, id},
+		Count:    int64(w.options.BatchSize),
+		Block:    block,
+	}).Result()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return nil, nil
+	case err != nil:
+		if w.missingGroup(ctx, err) {
+			return nil, nil
+		}
+		return nil, err
+	case len(streams) == 0:
+		return nil, nil
+	}
+	return streams[0].Messages, nil
+}
+
+// missingGroup creates the stream and group when err says they do not exist
+// (first start, or redis lost its data).
+func (w StockChangeWriter) missingGroup(ctx context.Context, err error) bool {
+	if !strings.HasPrefix(err.Error(), "NOGROUP") {
+		return false
+	}
+	err = w.redisClient.XGroupCreateMkStream(ctx, StockChangeStream, w.options.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		log.Println("stock changes: create group:", err)
+	}
+	return true
+}
+
+// flush applies one batch, retrying it a few times, and acknowledges it.
+// A batch that still fails stays pending for the reaper; flush then reports
+// false so the caller backs off.
+func (w StockChangeWriter) flush(ctx context.Context, messages []redis.XMessage) bool {
+	if len(messages) == 0 {
+		return true
+	}
+
+	ids := make([]string, 0, len(messages))
+	changes := make([]stockChange, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+		if len(message.Values) == 0 {
+			// pending but already deleted: flushed before, only the ack was lost
+			continue
+		}
+		change, err := parseStockChange(message)
+		if err != nil {
+			// it will never apply, retrying only blocks the stream
+			metrics.StockChanges.WithLabelValues("invalid").Inc()
+			log.Printf("stock changes: drop %v: %v", message.ID, err)
+			continue
+		}
+		changes = append(changes, change)
+	}
+
+	if len(changes) > 0 {
+		var err error
+		for attempt := 1; attempt <= w.options.Retries; attempt++ {
+			if _, err = w.productRepo.ApplyStockChanges(ctx, changes); err == nil {
+				break
+			}
+			w.sleep(ctx, time.Duration(attempt)*100*time.Millisecond)
+		}
+		if err != nil {
+			metrics.StockChanges.WithLabelValues("failed").Add(float64(len(changes)))
+			log.Printf("stock changes: apply %v changes (left pending): %v", len(changes), err)
+			return false
+		}
+		metrics.StockChanges.WithLabelValues("flushed").Add(float64(len(changes)))
+	}
+
+	pipe := w.redisClient.Pipeline()
+	pipe.XAck(ctx, StockChangeStream, w.options.Group, ids...)
+	pipe.XDel(ctx, StockChangeStream, ids...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		// applied already: the ledger makes the redelivery a no-op
+		log.Println("stock changes: ack:", err)
+	}
+	return true
+}
+
+func (w StockChangeWriter) sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+func parseStockChange(message redis.XMessage) (change stockChange, err error) {
+	change.ID = message.ID
+	product, _ := message.Values["product"].(string)
+	if change.ProductID, err = strconv.Atoi(product); err != nil {
+		return change, fmt.Errorf("product %q: %w", product, err)
+	}
+	delta, _ := message.Values["delta"].(string)
+	if change.Delta, err = strconv.Atoi(delta); err != nil {
+		return change, fmt.Errorf("delta %q: %w", delta, err)
+	}
+	return change, nil
+}
@@ -0,0 +1,202 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+	"gorm.io/gorm"
+)
+
+// failingChanges fails ApplyStockChanges the first fails times.
+type failingChanges struct {
+	ProductRepository
+	fails int32
+}
+
+func (r *failingChanges) ApplyStockChanges(ctx context.Context, changes []stockChange) ([]product, error) {
+	if atomic.AddInt32(&r.fails, -1) >= 0 {
+		return nil, errors.New("database down")
+	}
+	return r.ProductRepository.ApplyStockChanges(ctx, changes)
+}
+
+// newTestStock returns the seeded products table, its repository and a
+// redis with changes queued as (product, delta) pairs.
+func newTestStock(t *testing.T, changes ...int) (*gorm.DB, ProductRepository, redis.UniversalClient) {
+	t.Helper()
+	db := newTestDB(t)
+	productRepo := NewProductRepositoryDB(context.Background(), db, nil)
+	redisClient := newTestRedis(t, 1)[0]
+	queue := NewStockChangeQueueRedis(redisClient)
+	for i := 0; i+1 < len(changes); i += 2 {
+		if _, err := queue.EnqueueStockChange(context.Background(), changes[i], changes[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, productRepo, redisClient
+}
+
+func quantity(t *testing.T, db *gorm.DB, id int) int {
+	t.Helper()
+	p := product{}
+	if err := db.First(&p, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return p.Quantity
+}
+
+// streamState returns how many entries are left in the stream and pending
+// in the group.
+func streamState(t *testing.T, redisClient redis.UniversalClient, group string) (length int64, pending int64) {
+	t.Helper()
+	ctx := context.Background()
+	length, err := redisClient.XLen(ctx, StockChangeStream).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := redisClient.XPending(ctx, StockChangeStream, group).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return length, summary.Count
+}
+
+func TestStockChangeWriterCreatesGroup(t *testing.T) {
+	ctx := context.Background()
+	_, productRepo, redisClient := newTestStock(t)
+	w := NewStockChangeWriter(productRepo, redisClient, StockChangeWriterOptions{Consumer: "a"})
+
+	// first start, then redis losing its data: NOGROUP both times
+	for _, step := range []string{"first start", "after a flush"} {
+		if messages, err := w.read(ctx, ">", -1); err != nil || len(messages) != 0 {
+			t.Fatalf("%v: read = %v, %v, want nothing", step, messages, err)
+		}
+		groups, err := redisClient.XInfoGroups(ctx, StockChangeStream).Result()
+		if err != nil || len(groups) != 1 || groups[0].Name != "writers" {
+			t.Fatalf("%v: groups = %+v, %v, want writers", step, groups, err)
+		}
+		if err := redisClient.FlushAll(ctx).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStockChangeWriterRedelivery(t *testing.T) {
+	const productID = 1
+	ctx := context.Background()
+	db, productRepo, redisClient := newTestStock(t, productID, 5)
+	w := NewStockChangeWriter(productRepo, redisClient, StockChangeWriterOptions{Consumer: "a"})
+	before := quantity(t, db, productID)
+
+	w.read(ctx, ">", -1) // creates the group
+	messages, err := w.read(ctx, ">", -1)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("read = %v, %v, want 1 entry", messages, err)
+	}
+
+	// the second time as if the ack had been lost
+	for i := 0; i < 2; i++ {
+		if !w.flush(ctx, messages) {
+			t.Fatalf("flush %d failed", i)
+		}
+		if got := quantity(t, db, productID); got != before+5 {
+			t.Fatalf("flush %d: quantity = %d, want %d", i, got, before+5)
+		}
+	}
+	// a pending entry deleted after its flush comes back without values
+	if !w.flush(ctx, []redis.XMessage{{ID: messages[0].ID}}) {
+		t.Fatal("flush of a deleted entry failed")
+	}
+	if got := quantity(t, db, productID); got != before+5 {
+		t.Fatalf("quantity = %d, want %d", got, before+5)
+	}
+}
+
+func TestStockChangeWriterRetriesPending(t *testing.T) {
+	const productID = 2
+	ctx := context.Background()
+	db, productRepo, redisClient := newTestStock(t, productID, 3, productID, -1)
+	failing := &failingChanges{ProductRepository: productRepo, fails: 1}
+	w := NewStockChangeWriter(failing, redisClient, StockChangeWriterOptions{Consumer: "a", Retries: 1})
+	before := quantity(t, db, productID)
+
+	w.read(ctx, ">", -1) // creates the group
+	messages, err := w.read(ctx, ">", -1)
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("read = %v, %v, want 2 entries", messages, err)
+	}
+	if w.flush(ctx, messages) {
+		t.Fatal("flush succeeded with the database down")
+	}
+	if length, pending := streamState(t, redisClient, "writers"); length != 2 || pending != 2 {
+		t.Fatalf("after the failure: %d entries, %d pending, want 2 and 2", length, pending)
+	}
+	if got := quantity(t, db, productID); got != before {
+		t.Fatalf("after the failure: quantity = %d, want %d", got, before)
+	}
+
+	// what Run does first: this consumer's pending entries
+	w.flushAll(ctx, "0")
+	if length, pending := streamState(t, redisClient, "writers"); length != 0 || pending != 0 {
+		t.Fatalf("after the retry: %d entries, %d pending, want none", length, pending)
+	}
+	if got := quantity(t, db, productID); got != before+2 {
+		t.Fatalf("after the retry: quantity = %d, want %d", got, before+2)
+	}
+}
+
+func TestStockChangeWriterReapsDeadConsumer(t *testing.T) {
+	const productID = 3
+	ctx := context.Background()
+	db, productRepo, redisClient := newTestStock(t, productID, 4)
+	options := StockChangeWriterOptions{Consumer: "dead", ClaimIdle: 20 * time.Millisecond}
+	dead := NewStockChangeWriter(productRepo, redisClient, options)
+	options.Consumer = "alive"
+	alive := NewStockChangeWriter(productRepo, redisClient, options)
+	before := quantity(t, db, productID)
+
+	// read, then the instance crashes before the flush
+	dead.read(ctx, ">", -1) // creates the group
+	if messages, err := dead.read(ctx, ">", -1); err != nil || len(messages) != 1 {
+		t.Fatalf("read = %v, %v, want 1 entry", messages, err)
+	}
+
+	alive.reap(ctx)
+	if _, pending := streamState(t, redisClient, "writers"); pending != 1 {
+		t.Fatalf("claimed before ClaimIdle: %d pending, want 1", pending)
+	}
+
+	time.Sleep(2 * options.ClaimIdle)
+	alive.reap(ctx)
+	if length, pending := streamState(t, redisClient, "writers"); length != 0 || pending != 0 {
+		t.Fatalf("after the reap: %d entries, %d pending, want none", length, pending)
+	}
+	if got := quantity(t, db, productID); got != before+4 {
+		t.Fatalf("quantity = %d, want %d", got, before+4)
+	}
+}
+
+func TestStockChangeWriterDrains(t *testing.T) {
+	db, productRepo, redisClient := newTestStock(t, 4, 1, 5, 2, 4, 3)
+	w := NewStockChangeWriter(productRepo, redisClient, StockChangeWriterOptions{Consumer: "a", BatchSize: 2})
+	before := map[int]int{4: quantity(t, db, 4), 5: quantity(t, db, 5)}
+
+	// shut down before anything was read: Run only drains
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.read(context.Background(), ">", -1) // creates the group
+	w.Run(ctx)
+
+	if length, pending := streamState(t, redisClient, "writers"); length != 0 || pending != 0 {
+		t.Fatalf("after the drain: %d entries, %d pending, want none", length, pending)
+	}
+	for id, delta := range map[int]int{4: 4, 5: 2} {
+		if got := quantity(t, db, id); got != before[id]+delta {
+			t.Fatalf("product %d: quantity = %d, want %d", id, got, before[id]+delta)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package repositories
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"goredis/cache"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+	"gorm.io/gorm"
+)
+
+//	keys
+
+// Per product: stock::quantity::{<id>} mirrors products.quantity (loaded on
+// the first reservation), stock::held::{<id>} is a hash reservation ->
+// quantity and stock::expiry::{<id>} a ZSET reservation -> expiry (unix ms).
+// What can be reserved is quantity minus the sum of the unexpired holds. The
+// id is a hash tag so the scripts see the three keys in one cluster slot.
+const (
+	stockQuantity = "stock::quantity"
+	stockHeld     = "stock::held"
+	stockExpiry   = "stock::expiry"
+)
+
+func stockKeys(id int) []string {
+	tag := cache.Tag(strconv.Itoa(id))
+	return []string{
+		cache.Key(stockQuantity, tag),
+		cache.Key(stockHeld, tag),
+		cache.Key(stockExpiry, tag),
+	}
+}
+
+//	scripts
+
+const (
+	stockReserved     = 1
+	stockInsufficient = 0
+	stockNotLoaded    = -1
+)
+
+// reserveScript drops the expired holds, then adds the new one if enough is
+// left. It returns {status, available after the call}.
+var reserveScript = redis.NewScript(`
+local quantity = redis.call("GET", KEYS[1])
+if not quantity then
+	return {-1, 0}
+end
+
+local expired = redis.call("ZRANGEBYSCORE", KEYS[3], "-inf", ARGV[1])
+if #expired > 0 then
+	redis.call("HDEL", KEYS[2], unpack(expired))
+	redis.call("ZREMRANGEBYSCORE", KEYS[3], "-inf", ARGV[1])
+end
+
+local available = tonumber(quantity)
+for _, held in ipairs(redis.call("HVALS", KEYS[2])) do
+	available = available - tonumber(held)
+end
+
+local want = tonumber(ARGV[2])
+if want > available then
+	return {0, available}
+end
+redis.call("HSET", KEYS[2], ARGV[3], want)
+redis.call("ZADD", KEYS[3], ARGV[4], ARGV[3])
+return {1, available - want}
+`)
+
+// settleScript removes an unexpired hold and returns its quantity (-1 when
+// there is none). With ARGV[3] = "commit" the quantity also leaves the
+// mirrored stock, as it is about to leave the products table.
+var settleScript = redis.NewScript(`
+local held = redis.call("HGET", KEYS[2], ARGV[1])
+local expiry = redis.call("ZSCORE", KEYS[3], ARGV[1])
+redis.call("HDEL", KEYS[2], ARGV[1])
+redis.call("ZREM", KEYS[3], ARGV[1])
+if not held or not expiry or tonumber(expiry) <= tonumber(ARGV[2]) then
+	return -1
+end
+if ARGV[3] == "commit" and redis.call("EXISTS", KEYS[1]) == 1 then
+	redis.call("DECRBY", KEYS[1], held)
+end
+return tonumber(held)
+`)
+
+//	adapter
+
+// productRepositoryStock keeps reservations in Redis so the check and the
+// hold are one atomic script: concurrent reservations cannot oversell. It
+// sits on top of the repository chain so every write that changes a
+// quantity also resets the mirror, but seeds the mirror from db itself: a
+// cached quantity may be stale.
+type productRepositoryStock struct {
+	productRepo ProductRepository
+	db          *gorm.DB
+	redisClient redis.UniversalClient
+}
+
+func NewProductRepositoryStock(productRepo ProductRepository, db *gorm.DB, redisClient redis.UniversalClient) StockRepository {
+	return productRepositoryStock{productRepo: productRepo, db: db, redisClient: redisClient}
+}
+
+//	method
+
+func (r productRepositoryStock) GetProducts(ctx context.Context, query ProductQuery) (productPage, error) {
+	return r.productRepo.GetProducts(ctx, query)
+}
+
+func (r productRepositoryStock) GetProduct(ctx context.Context, id int) (product, error) {
+	return r.productRepo.GetProduct(ctx, id)
+}
+
+func (r productRepositoryStock) GetProductRank(ctx context.Context, id int) (int64, error) {
+	return r.productRepo.GetProductRank(ctx, id)
+}
+
+//	reservation
+
+func (r productRepositoryStock) ReserveStock(ctx context.Context, id int, quantity int, ttl time.Duration) (res reservation, err error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return res, err
+	}
+	now := time.Now()
+	res = reservation{
+		ID:        hex.EncodeToString(token),
+		ProductID: id,
+		Quantity:  quantity,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	for loaded := false; ; loaded = true {
+		result, err := reserveScript.Run(ctx, r.redisClient, stockKeys(id),
+			now.UnixMilli(), quantity, res.ID, res.ExpiresAt.UnixMilli()).Int64Slice()
+		if err != nil {
+			return res, err
+		}
+		res.Available = result[1]
+
+		switch {
+		case result[0] == stockReserved:
+			return res, nil
+		case result[0] == stockInsufficient:
+			return res, ErrInsufficientStock
+		case result[0] != stockNotLoaded || loaded:
+			return res, fmt.Errorf("stock: reserve product %v: unexpected result %v", id, result)
+		}
+		if err := r.load(ctx, id); err != nil {
+			return res, err
+		}
+	}
+}
+
+func (r productRepositoryStock) ReleaseStock(ctx context.Context, id int, reservationID string) error {
+	_, err := r.settle(ctx, id, reservationID, "release")
+	return err
+}
+
+// CommitStock persists a reservation: the hold is removed and its quantity
+// taken from the products table. If that fails the hold is gone anyway, like
+// a release, and the mirror is reloaded from the table.
+func (r productRepositoryStock) CommitStock(ctx context.Context, id int, reservationID string) (p product, err error) {
+	quantity, err := r.settle(ctx, id, reservationID, "commit")
+	if err != nil {
+		return p, err
+	}
+	p, err = r.productRepo.DecrementQuantity(ctx, id, quantity)
+	if err != nil {
+		r.forget(context.Background(), id)
+		return p, err
+	}
+	// commits do not go through the service / handler decorators
+	cache.InvalidateProductFields(context.Background(), r.redisClient, []string{"quantity"}, id)
+	return p, nil
+}
+
+func (r productRepositoryStock) settle(ctx context.Context, id int, reservationID string, mode string) (int, error) {
+	quantity, err := settleScript.Run(ctx, r.redisClient, stockKeys(id),
+		reservationID, time.Now().UnixMilli(), mode).Int()
+	if err != nil {
+		return 0, err
+	}
+	if quantity < 0 {
+		return 0, ErrReservationNotFound
+	}
+	return quantity, nil
+}
+
+// load mirrors the quantity of the products table, unless another caller
+// already did. It reads the table, not the repository chain: the cache
+// decorators could answer with a stale quantity.
+func (r productRepositoryStock) load(ctx context.Context, id int) error {
+	var quantities []int
+	err := r.db.WithContext(ctx).Model(&product{}).Where("id = ?", id).Pluck("quantity", &quantities).Error
+	if err != nil {
+		return err
+	}
+	if len(quantities) == 0 {
+		return ErrProductNotFound
+	}
+	return r.redisClient.SetNX(ctx, stockKeys(id)[0], quantities[0], 0).Err()
+}
+
+// forget drops the mirrored quantity so the next reservation reloads it; the
+// holds stay and are counted against the new quantity.
+func (r productRepositoryStock) forget(ctx context.Context, id int) {
+	if err := r.redisClient.Del(ctx, stockKeys(id)[0]).Err(); err != nil {
+		log.Println("stock: forget quantity:", err)
+	}
+}
+
+//	write (repository then reset the mirror)
+
+func (r productRepositoryStock) CreateProduct(ctx context.Context, draft ProductDraft) (product, error) {
+	return r.productRepo.CreateProduct(ctx, draft)
+}
+
+func (r productRepositoryStock) UpdateProduct(ctx context.Context, id int, fields map[string]interface{}) (product, error) {
+	p, err := r.productRepo.UpdateProduct(ctx, id, fields)
+	if err != nil {
+		return p, err
+	}
+	r.forget(context.Background(), id)
+	return p, nil
+}
+
+func (r productRepositoryStock) DeleteProduct(ctx context.Context, id int) error {
+	err := r.productRepo.DeleteProduct(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := r.redisClient.Del(context.Background(), stockKeys(id)...).Err(); err != nil {
+		log.Println("stock: delete product:", err)
+	}
+	return nil
+}
+
+func (r productRepositoryStock) DecrementQuantity(ctx context.Context, id int, quantity int) (product, error) {
+	p, err := r.productRepo.DecrementQuantity(ctx, id, quantity)
+	if err != nil {
+		return p, err
+	}
+	r.forget(context.Background(), id)
+	return p, nil
+}
+
+func (r productRepositoryStock) ApplyStockChanges(ctx context.Context, changes []stockChange) ([]product, error) {
+	products, err := r.productRepo.ApplyStockChanges(ctx, changes)
+	if err != nil {
+		return products, err
+	}
+	for _, p := range products {
+		r.forget(context.Background(), p.ID)
+	}
+	// the write-behind writer does not go through the service / handler
+	// decorators either
+	if len(products) > 0 {
+		cache.InvalidateProductFields(context.Background(), r.redisClient, []string{"quantity"}, productIDs(products)...)
+	}
+	return products, nil
+}
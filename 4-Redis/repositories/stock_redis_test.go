@@ -0,0 +1,179 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one connection: every connection to :memory: is a new database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func newTestRedis(t *testing.T, clients int) []redis.UniversalClient {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	redisClients := []redis.UniversalClient{}
+	for i := 0; i < clients; i++ {
+		redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr(), PoolSize: 50})
+		t.Cleanup(func() { redisClient.Close() })
+		redisClients = append(redisClients, redisClient)
+	}
+	return redisClients
+}
+
+// race runs f n times, all goroutines released at once.
+func race(n int, f func(i int)) {
+	ready := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-ready
+			f(i)
+		}(i)
+	}
+	close(ready)
+	wg.Wait()
+}
+
+func TestReserveNoOversell(t *testing.T) {
+	const (
+		productID = 1
+		stock     = 100
+		clients   = 1000
+		instances = 4 // app instances sharing the stock
+	)
+	ctx := context.Background()
+	db := newTestDB(t)
+	productRepo := NewProductRepositoryDB(context.Background(), db, nil)
+
+	stockRepos := []StockRepository{}
+	for _, redisClient := range newTestRedis(t, instances) {
+		stockRepos = append(stockRepos, NewProductRepositoryStock(productRepo, db, redisClient))
+	}
+	if _, err := stockRepos[0].UpdateProduct(ctx, productID, map[string]interface{}{"quantity": stock}); err != nil {
+		t.Fatal(err)
+	}
+
+	//	every client reserves 1 at the same time: exactly stock succeed
+	mu := sync.Mutex{}
+	held := []reservation{}
+	conflicts := 0
+	race(clients, func(i int) {
+		r, err := stockRepos[i%instances].ReserveStock(ctx, productID, 1, time.Minute)
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err == nil:
+			held = append(held, r)
+		case errors.Is(err, ErrInsufficientStock):
+			conflicts++
+		default:
+			t.Errorf("reserve: %v", err)
+		}
+	})
+	if len(held) != stock || conflicts != clients-stock {
+		t.Fatalf("%d held and %d conflicts, want %d and %d", len(held), conflicts, stock, clients-stock)
+	}
+
+	//	commit half, release the other half, concurrently
+	race(len(held), func(i int) {
+		stockRepo := stockRepos[(i+1)%instances]
+		if i%2 == 0 {
+			if _, err := stockRepo.CommitStock(ctx, productID, held[i].ID); err != nil {
+				t.Errorf("commit: %v", err)
+			}
+			return
+		}
+		if err := stockRepo.ReleaseStock(ctx, productID, held[i].ID); err != nil {
+			t.Errorf("release: %v", err)
+		}
+	})
+	p, err := productRepo.GetProduct(ctx, productID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := stock - stock/2; p.Quantity != want {
+		t.Fatalf("quantity after the commits = %d, want %d", p.Quantity, want)
+	}
+
+	//	a settled reservation cannot be used twice
+	for _, r := range held[:2] {
+		if _, err := stockRepos[0].CommitStock(ctx, productID, r.ID); !errors.Is(err, ErrReservationNotFound) {
+			t.Fatalf("second commit of %v: err = %v, want ErrReservationNotFound", r.ID, err)
+		}
+	}
+
+	//	what is left can be reserved again, and holds expire
+	rest, err := stockRepos[0].ReserveStock(ctx, productID, p.Quantity, 100*time.Millisecond)
+	if err != nil || rest.Available != 0 {
+		t.Fatalf("reserve the rest = %+v, %v", rest, err)
+	}
+	if _, err := stockRepos[1].ReserveStock(ctx, productID, 1, time.Minute); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("reserve over the rest: err = %v, want ErrInsufficientStock", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, err := stockRepos[1].ReserveStock(ctx, productID, p.Quantity, time.Minute); err != nil {
+		t.Fatalf("reserve after the expiry: %v", err)
+	}
+}
+
+// staleRepository answers reads with an old quantity, like a cache decorator
+// before its entry is invalidated.
+type staleRepository struct {
+	ProductRepository
+	quantity int
+}
+
+func (r staleRepository) GetProduct(ctx context.Context, id int) (product, error) {
+	p, err := r.ProductRepository.GetProduct(ctx, id)
+	p.Quantity = r.quantity
+	return p, err
+}
+
+func TestReserveSeedsFromDatabase(t *testing.T) {
+	const productID = 1
+	ctx := context.Background()
+	db := newTestDB(t)
+	productRepo := NewProductRepositoryDB(context.Background(), db, nil)
+	if _, err := productRepo.UpdateProduct(ctx, productID, map[string]interface{}{"quantity": 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	stockRepo := NewProductRepositoryStock(staleRepository{productRepo, 1000}, db, newTestRedis(t, 1)[0])
+	if _, err := stockRepo.ReserveStock(ctx, productID, 6, time.Minute); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("reserve 6 of 5: err = %v, want ErrInsufficientStock", err)
+	}
+	r, err := stockRepo.ReserveStock(ctx, productID, 5, time.Minute)
+	if err != nil || r.Available != 0 {
+		t.Fatalf("reserve 5 of 5 = %+v, %v", r, err)
+	}
+
+	if _, err := stockRepo.ReserveStock(ctx, 999999, 1, time.Minute); !errors.Is(err, ErrProductNotFound) {
+		t.Fatalf("reserve an unknown product: err = %v, want ErrProductNotFound", err)
+	}
+}
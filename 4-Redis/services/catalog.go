@@ -1,11 +1,114 @@
 package services
 
+import (
+	"context"
+	"errors"
+	"goredis/repositories"
+	"time"
+)
+
 type Product struct {
-	ID       int    `json:"id"`
-	Name     string `json:"name"`
-	Quantity int    `json:"quantity"`
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Quantity  int       `json:"quantity"`
+	Price     int64     `json:"price"` // in cents
+	SKU       string    `json:"sku"`
+	Category  *Category `json:"category"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Category struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type ProductQuery = repositories.ProductQuery
+
+type Paging struct {
+	Limit      int   `json:"limit"`
+	Offset     int   `json:"offset"`
+	Total      int64 `json:"total"`
+	NextOffset *int  `json:"next_offset"`
+}
+
+type ProductRank struct {
+	ID   int   `json:"id"`
+	Rank int64 `json:"rank"`
+}
+
+type ProductPage struct {
+	Products []Product `json:"products"`
+	Paging   Paging    `json:"paging"`
+}
+
+// ProductInput is the body of a product write. CreateProduct requires name
+// and quantity and generates a missing sku. UpdateProduct replaces the
+// product: it requires name, quantity and sku, and resets a missing price
+// and category (see Replace). PatchProduct does not touch the fields left
+// nil. A category_id of 0 removes the category.
+type ProductInput struct {
+	Name       *string `json:"name"`
+	Quantity   *int    `json:"quantity"`
+	Price      *int64  `json:"price"`
+	SKU        *string `json:"sku"`
+	CategoryID *int    `json:"category_id"`
+}
+
+// Replace is the input UpdateProduct writes: the price and category left
+// out are reset to 0 and none.
+func (input ProductInput) Replace() ProductInput {
+	if input.Price == nil {
+		input.Price = new(int64)
+	}
+	if input.CategoryID == nil {
+		input.CategoryID = new(int)
+	}
+	return input
+}
+
+// Fields are the product columns the input writes.
+func (input ProductInput) Fields() []string {
+	fields := []string{}
+	if input.Name != nil {
+		fields = append(fields, "name")
+	}
+	if input.Quantity != nil {
+		fields = append(fields, "quantity")
+	}
+	if input.Price != nil {
+		fields = append(fields, "price")
+	}
+	if input.SKU != nil {
+		fields = append(fields, "sku")
+	}
+	if input.CategoryID != nil {
+		fields = append(fields, "category_id")
+	}
+	return fields
+}
+
+func productIDs(products []Product) []int {
+	ids := make([]int, 0, len(products))
+	for _, p := range products {
+		ids = append(ids, p.ID)
+	}
+	return ids
 }
 
 type CatalogService interface {
-	GetProducts() ([]Product, error)
+	GetProducts(ctx context.Context, query ProductQuery) (ProductPage, error)
+	GetProduct(ctx context.Context, id int) (Product, error)
+	GetProductRank(ctx context.Context, id int) (ProductRank, error)
+	CreateProduct(ctx context.Context, input ProductInput) (Product, error)
+	UpdateProduct(ctx context.Context, id int, input ProductInput) (Product, error)
+	PatchProduct(ctx context.Context, id int, input ProductInput) (Product, error)
+	DeleteProduct(ctx context.Context, id int) error
 }
+
+var (
+	ErrProductNotFound  = errors.New("product not found")
+	ErrInvalidProduct   = errors.New("invalid product")
+	ErrCategoryNotFound = errors.New("category not found")
+	ErrDuplicateSKU     = errors.New("sku already used")
+)
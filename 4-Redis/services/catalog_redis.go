@@ -2,54 +2,120 @@ package services
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"goredis/repositories"
+	"goredis/cache"
 	"time"
 
 	"github.com/go-redis/redis/v9"
 )
 
 type catalogServiceRedis struct {
-	productRepo repositories.ProductRepository
-	redisClient *redis.Client
+	catalogSrv  CatalogService
+	redisClient redis.UniversalClient
+	products    cache.Aside[ProductQuery, ProductPage]
+	product     cache.Aside[int, Product]
 }
 
-func NewCatalogServiceRedis(productRepo repositories.ProductRepository, redisClient *redis.Client) CatalogService {
-	return catalogServiceRedis{productRepo: productRepo, redisClient: redisClient}
+// NewCatalogServiceRedis caches the results of any CatalogService.
+func NewCatalogServiceRedis(catalogSrv CatalogService, redisClient redis.UniversalClient, settings cache.Settings) CatalogService {
+	return catalogServiceRedis{
+		catalogSrv:  catalogSrv,
+		redisClient: redisClient,
+		products: cache.NewAside(redisClient, cache.Options[ProductQuery, ProductPage]{
+			Name:   "service",
+			Family: cache.ServiceGetProducts,
+			Key: func(query ProductQuery) string {
+				return cache.Key(cache.ServiceGetProducts, query.CacheKey())
+			},
+			TTL:          settings.TTL,
+			Stale:        settings.Stale,
+			EarlyRefresh: settings.EarlyRefresh,
+			Local:        settings.Local,
+			Codec:        cache.NewCodec[ProductPage](settings.Format),
+			Tags: func(query ProductQuery, page ProductPage) []string {
+				return append(query.CacheTags(), cache.ProductTags(productIDs(page.Products)...)...)
+			},
+			// one rebuild across all instances when a hot page expires
+			Lock: cache.LockOptions{
+				TTL:  time.Second * 3,
+				Wait: time.Millisecond * 500,
+			},
+		}),
+		product: cache.NewAside(redisClient, cache.Options[int, Product]{
+			Name:   "service",
+			Family: cache.ServiceGetProduct,
+			Key: func(id int) string {
+				return cache.ProductKey(cache.ServiceGetProduct, id)
+			},
+			TTL:          settings.TTL,
+			Stale:        settings.Stale,
+			EarlyRefresh: settings.EarlyRefresh,
+			Local:        settings.Local,
+			Codec:        cache.NewCodec[Product](settings.Format),
+			Missing:      ErrProductNotFound,
+			MissingTTL:   settings.MissingTTL,
+			Tags: func(id int, _ Product) []string {
+				return []string{cache.ProductTag(id)}
+			},
+		}),
+	}
+}
+
+func (s catalogServiceRedis) GetProducts(ctx context.Context, query ProductQuery) (ProductPage, error) {
+	page, err := s.products.Get(ctx, query.Normalize(), s.catalogSrv.GetProducts)
+	if err == nil && page.Products == nil {
+		// gob decodes an empty page as nil, which would render as null
+		page.Products = []Product{}
+	}
+	return page, err
 }
 
-func (s catalogServiceRedis) GetProducts() (products []Product, err error) {
+// WarmProducts loads a listing into the cache ahead of the requests.
+func (s catalogServiceRedis) WarmProducts(ctx context.Context, query ProductQuery) error {
+	return s.products.Warm(ctx, query.Normalize(), s.catalogSrv.GetProducts)
+}
 
-	key := "service::GetProducts"
+func (s catalogServiceRedis) GetProduct(ctx context.Context, id int) (Product, error) {
+	return s.product.Get(ctx, id, s.catalogSrv.GetProduct)
+}
 
-	// 	redis get
-	if productsJson, err := s.redisClient.Get(context.Background(), key).Result(); err == nil {
-		if json.Unmarshal([]byte(productsJson), &products) == nil {
-			fmt.Println("redis")
-			return products, nil
-		}
-	}
+func (s catalogServiceRedis) GetProductRank(ctx context.Context, id int) (ProductRank, error) {
+	return s.catalogSrv.GetProductRank(ctx, id)
+}
 
-	// 	repository
-	productsDB, err := s.productRepo.GetProducts()
+//	write (service then invalidate)
+
+func (s catalogServiceRedis) CreateProduct(ctx context.Context, input ProductInput) (Product, error) {
+	product, err := s.catalogSrv.CreateProduct(ctx, input)
 	if err != nil {
-		return nil, err
+		return product, err
 	}
+	cache.InvalidateProducts(context.Background(), s.redisClient, product.ID)
+	return product, nil
+}
 
-	for _, p := range productsDB {
-		products = append(products, Product{
-			ID:       p.ID,
-			Name:     p.Name,
-			Quantity: p.Quantity,
-		})
+func (s catalogServiceRedis) UpdateProduct(ctx context.Context, id int, input ProductInput) (Product, error) {
+	product, err := s.catalogSrv.UpdateProduct(ctx, id, input)
+	if err != nil {
+		return product, err
 	}
+	cache.InvalidateProductFields(context.Background(), s.redisClient, input.Replace().Fields(), id)
+	return product, nil
+}
 
-	// 	redis set
-	if data, err := json.Marshal(products); err == nil {
-		s.redisClient.Set(context.Background(), key, string(data), time.Second*10)
+func (s catalogServiceRedis) PatchProduct(ctx context.Context, id int, input ProductInput) (Product, error) {
+	product, err := s.catalogSrv.PatchProduct(ctx, id, input)
+	if err != nil {
+		return product, err
 	}
+	cache.InvalidateProductFields(context.Background(), s.redisClient, input.Fields(), id)
+	return product, nil
+}
 
-	fmt.Println("database")
-	return products, nil
+func (s catalogServiceRedis) DeleteProduct(ctx context.Context, id int) error {
+	err := s.catalogSrv.DeleteProduct(ctx, id)
+	if err != nil {
+		return err
+	}
+	cache.InvalidateProducts(context.Background(), s.redisClient, id)
+	return nil
 }
@@ -1,6 +1,10 @@
 package services
 
-import "goredis/repositories"
+import (
+	"context"
+	"errors"
+	"goredis/repositories"
+)
 
 type catalogService struct {
 	productRepo repositories.ProductRepository
@@ -10,20 +14,163 @@ func NewCatalogService(productRepo repositories.ProductRepository) CatalogServic
 	return catalogService{productRepo: productRepo}
 }
 
-func (s catalogService) GetProducts() (products []Product, err error) {
+func (s catalogService) GetProducts(ctx context.Context, query ProductQuery) (page ProductPage, err error) {
 
-	productsDB, err := s.productRepo.GetProducts()
+	query = query.Normalize()
+	pageDB, err := s.productRepo.GetProducts(ctx, query)
 	if err != nil {
-		return nil, err
+		return page, repositoryError(err)
 	}
 
-	for _, p := range productsDB {
-		products = append(products, Product{
-			ID:       p.ID,
-			Name:     p.Name,
-			Quantity: p.Quantity,
-		})
+	page.Products = []Product{}
+	for _, p := range pageDB.Products {
+		page.Products = append(page.Products, newProduct(p))
 	}
+	page.Paging = newPaging(query, len(page.Products), pageDB.Total)
 
-	return products, nil
+	return page, nil
+}
+
+func (s catalogService) GetProduct(ctx context.Context, id int) (Product, error) {
+	p, err := s.productRepo.GetProduct(ctx, id)
+	if err != nil {
+		return Product{}, repositoryError(err)
+	}
+	return newProduct(p), nil
+}
+
+func (s catalogService) GetProductRank(ctx context.Context, id int) (ProductRank, error) {
+	rank, err := s.productRepo.GetProductRank(ctx, id)
+	if err != nil {
+		return ProductRank{}, repositoryError(err)
+	}
+	return ProductRank{ID: id, Rank: rank}, nil
+}
+
+func (s catalogService) CreateProduct(ctx context.Context, input ProductInput) (Product, error) {
+	if input.Name == nil || input.Quantity == nil || !validProduct(input) {
+		return Product{}, ErrInvalidProduct
+	}
+
+	draft := repositories.ProductDraft{Name: *input.Name, Quantity: *input.Quantity}
+	if input.Price != nil {
+		draft.Price = *input.Price
+	}
+	if input.SKU != nil {
+		draft.SKU = *input.SKU
+	}
+	if input.CategoryID != nil && *input.CategoryID != 0 {
+		draft.CategoryID = input.CategoryID
+	}
+
+	p, err := s.productRepo.CreateProduct(ctx, draft)
+	if err != nil {
+		return Product{}, repositoryError(err)
+	}
+	return newProduct(p), nil
+}
+
+func (s catalogService) UpdateProduct(ctx context.Context, id int, input ProductInput) (Product, error) {
+	if input.Name == nil || input.Quantity == nil || input.SKU == nil {
+		return Product{}, ErrInvalidProduct
+	}
+	return s.PatchProduct(ctx, id, input.Replace())
+}
+
+func (s catalogService) PatchProduct(ctx context.Context, id int, input ProductInput) (Product, error) {
+	if !validProduct(input) {
+		return Product{}, ErrInvalidProduct
+	}
+
+	fields := map[string]interface{}{}
+	if input.Name != nil {
+		fields["name"] = *input.Name
+	}
+	if input.Quantity != nil {
+		fields["quantity"] = *input.Quantity
+	}
+	if input.Price != nil {
+		fields["price"] = *input.Price
+	}
+	if input.SKU != nil {
+		fields["sku"] = *input.SKU
+	}
+	if input.CategoryID != nil {
+		var categoryID *int
+		if *input.CategoryID != 0 {
+			categoryID = input.CategoryID
+		}
+		fields["category_id"] = categoryID
+	}
+
+	p, err := s.productRepo.UpdateProduct(ctx, id, fields)
+	if err != nil {
+		return Product{}, repositoryError(err)
+	}
+	return newProduct(p), nil
+}
+
+func (s catalogService) DeleteProduct(ctx context.Context, id int) error {
+	return repositoryError(s.productRepo.DeleteProduct(ctx, id))
+}
+
+//	helper
+
+func newPaging(query ProductQuery, count int, total int64) Paging {
+	paging := Paging{Limit: query.Limit, Offset: query.Offset, Total: total}
+	if next := query.Offset + count; count > 0 && int64(next) < total {
+		paging.NextOffset = &next
+	}
+	return paging
+}
+
+func validProduct(input ProductInput) bool {
+	if input.Name != nil && *input.Name == "" {
+		return false
+	}
+	if input.Quantity != nil && *input.Quantity < 0 {
+		return false
+	}
+	if input.Price != nil && *input.Price < 0 {
+		return false
+	}
+	if input.SKU != nil && (*input.SKU == "" || len(*input.SKU) > 32) {
+		return false
+	}
+	if input.CategoryID != nil && *input.CategoryID < 0 {
+		return false
+	}
+	return true
+}
+
+func newProduct(p repositories.ProductRow) Product {
+	return Product{
+		ID:        p.ID,
+		Name:      p.Name,
+		Quantity:  p.Quantity,
+		Price:     p.Price,
+		SKU:       p.SKU,
+		Category:  newCategory(p.Category),
+		CreatedAt: p.CreatedAt.UTC(),
+		UpdatedAt: p.UpdatedAt.UTC(),
+	}
+}
+
+func newCategory(category *repositories.Category) *Category {
+	if category == nil {
+		return nil
+	}
+	return &Category{ID: category.ID, Name: category.Name}
+}
+
+func repositoryError(err error) error {
+	switch {
+	case errors.Is(err, repositories.ErrProductNotFound):
+		return ErrProductNotFound
+	case errors.Is(err, repositories.ErrCategoryNotFound):
+		return ErrCategoryNotFound
+	case errors.Is(err, repositories.ErrDuplicateSKU):
+		return ErrDuplicateSKU
+	}
+	return err
 }
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"goredis/repositories"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestCatalog(t *testing.T) CatalogService {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one connection: every connection to :memory: is a new database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return NewCatalogService(repositories.NewProductRepositoryDB(context.Background(), db, nil))
+}
+
+func TestUpdateProduct(t *testing.T) {
+	name, quantity, price, sku, categoryID := "Renamed", 7, int64(500), "SKU-TEST", 1
+
+	tests := []struct {
+		name         string
+		write        string // PUT or PATCH
+		input        ProductInput
+		wantErr      error
+		wantPrice    int64
+		wantSKU      string
+		wantCategory bool
+	}{
+		{
+			name:         "put every field",
+			write:        "PUT",
+			input:        ProductInput{Name: &name, Quantity: &quantity, Price: &price, SKU: &sku, CategoryID: &categoryID},
+			wantPrice:    price,
+			wantSKU:      sku,
+			wantCategory: true,
+		},
+		{
+			name:      "put resets price and category",
+			write:     "PUT",
+			input:     ProductInput{Name: &name, Quantity: &quantity, SKU: &sku},
+			wantPrice: 0,
+			wantSKU:   sku,
+		},
+		{name: "put without sku", write: "PUT", input: ProductInput{Name: &name, Quantity: &quantity}, wantErr: ErrInvalidProduct},
+		{name: "put without name", write: "PUT", input: ProductInput{Quantity: &quantity, SKU: &sku}, wantErr: ErrInvalidProduct},
+		{
+			name:         "patch keeps the other fields",
+			write:        "PATCH",
+			input:        ProductInput{Name: &name},
+			wantPrice:    price,
+			wantSKU:      "SKU-BEFORE",
+			wantCategory: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			catalogSrv := newTestCatalog(t)
+			before, beforeSKU := "Before", "SKU-BEFORE"
+			created, err := catalogSrv.CreateProduct(ctx, ProductInput{Name: &before, Quantity: &quantity, Price: &price, SKU: &beforeSKU, CategoryID: &categoryID})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			write := catalogSrv.UpdateProduct
+			if tt.write == "PATCH" {
+				write = catalogSrv.PatchProduct
+			}
+			p, err := write(ctx, created.ID, tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if p.Name != name || p.Price != tt.wantPrice || p.SKU != tt.wantSKU || (p.Category != nil) != tt.wantCategory {
+				t.Fatalf("product = %+v, want price %v, sku %v, a category %v", p, tt.wantPrice, tt.wantSKU, tt.wantCategory)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"goredis/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type catalogServiceTracing struct {
+	catalogSrv CatalogService
+}
+
+// NewCatalogServiceTracing records a span per CatalogService call, around
+// the service cache when there is one (its cache.Get span is a child).
+func NewCatalogServiceTracing(catalogSrv CatalogService) CatalogService {
+	return catalogServiceTracing{catalogSrv: catalogSrv}
+}
+
+// answers, not failures: the span is not marked as an error
+var answerErrors = []error{ErrProductNotFound, ErrInvalidProduct, ErrCategoryNotFound, ErrDuplicateSKU}
+
+func (s catalogServiceTracing) GetProducts(ctx context.Context, query ProductQuery) (page ProductPage, err error) {
+	ctx, span := tracing.Start(ctx, "CatalogService.GetProducts", attribute.String("catalog.query", query.CacheKey()))
+	defer func() { tracing.End(span, err, answerErrors...) }()
+
+	page, err = s.catalogSrv.GetProducts(ctx, query)
+	span.SetAttributes(attribute.Int("catalog.products", len(page.Products)))
+	return page, err
+}
+
+func (s catalogServiceTracing) GetProduct(ctx context.Context, id int) (p Product, err error) {
+	ctx, span := tracing.Start(ctx, "CatalogService.GetProduct", attribute.Int("product.id", id))
+	defer func() { tracing.End(span, err, answerErrors...) }()
+	return s.catalogSrv.GetProduct(ctx, id)
+}
+
+func (s catalogServiceTracing) GetProductRank(ctx context.Context, id int) (rank ProductRank, err error) {
+	ctx, span := tracing.Start(ctx, "CatalogService.GetProductRank", attribute.Int("product.id", id))
+	defer func() { tracing.End(span, err, answerErrors...) }()
+	return s.catalogSrv.GetProductRank(ctx, id)
+}
+
+//	write
+
+func (s catalogServiceTracing) CreateProduct(ctx context.Context, input ProductInput) (p Product, err error) {
+	ctx, span := tracing.Start(ctx, "CatalogService.CreateProduct")
+	defer func() { tracing.End(span, err, answerErrors...) }()
+
+	p, err = s.catalogSrv.CreateProduct(ctx, input)
+	span.SetAttributes(attribute.Int("product.id", p.ID))
+	return p, err
+}
+
+func (s catalogServiceTracing) UpdateProduct(ctx context.Context, id int, input ProductInput) (p Product, err error) {
+	ctx, span := tracing.Start(ctx, "CatalogService.UpdateProduct",
+		attribute.Int("product.id", id),
+		attribute.StringSlice("product.fields", input.Replace().Fields()))
+	defer func() { tracing.End(span, err, answerErrors...) }()
+	return s.catalogSrv.UpdateProduct(ctx, id, input)
+}
+
+func (s catalogServiceTracing) PatchProduct(ctx context.Context, id int, input ProductInput) (p Product, err error) {
+	ctx, span := tracing.Start(ctx, "CatalogService.PatchProduct",
+		attribute.Int("product.id", id),
+		attribute.StringSlice("product.fields", input.Fields()))
+	defer func() { tracing.End(span, err, answerErrors...) }()
+	return s.catalogSrv.PatchProduct(ctx, id, input)
+}
+
+func (s catalogServiceTracing) DeleteProduct(ctx context.Context, id int) (err error) {
+	ctx, span := tracing.Start(ctx, "CatalogService.DeleteProduct", attribute.Int("product.id", id))
+	defer func() { tracing.End(span, err, answerErrors...) }()
+	return s.catalogSrv.DeleteProduct(ctx, id)
+}
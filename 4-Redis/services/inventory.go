@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type Reservation struct {
+	ID        string    `json:"id"`
+	ProductID int       `json:"product_id"`
+	Quantity  int       `json:"quantity"`
+	Available int64     `json:"available"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ReservationInput is the body of a reserve (Quantity) or of a release and
+// commit (Reservation, the id returned by the reserve).
+type ReservationInput struct {
+	Quantity    int    `json:"quantity"`
+	Reservation string `json:"reservation"`
+}
+
+// StockChange is a queued quantity change; it reaches the products table
+// when the write-behind writer flushes it.
+type StockChange struct {
+	ID        string `json:"id"`
+	ProductID int    `json:"product_id"`
+	Delta     int    `json:"delta"`
+}
+
+type StockChangeInput struct {
+	Delta int `json:"delta"`
+}
+
+type InventoryService interface {
+	Reserve(ctx context.Context, id int, input ReservationInput) (Reservation, error)
+	Release(ctx context.Context, id int, input ReservationInput) error
+	Commit(ctx context.Context, id int, input ReservationInput) (Product, error)
+	ChangeStock(ctx context.Context, id int, input StockChangeInput) (StockChange, error)
+}
+
+var (
+	ErrInsufficientStock   = errors.New("insufficient stock")
+	ErrReservationNotFound = errors.New("reservation not found")
+	ErrInvalidReservation  = errors.New("invalid reservation")
+	ErrInvalidStockChange  = errors.New("invalid stock change")
+	ErrWriteBehindDisabled = errors.New("write-behind is disabled")
+)
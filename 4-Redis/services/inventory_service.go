@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"goredis/repositories"
+	"time"
+)
+
+type inventoryService struct {
+	stockRepo  repositories.StockRepository
+	stockQueue repositories.StockChangeQueue
+	ttl        time.Duration
+}
+
+// NewInventoryService holds reserved stock for ttl; holds that are neither
+// released nor committed by then go back to the available stock. Stock
+// changes go to stockQueue (nil when write-behind is off).
+func NewInventoryService(stockRepo repositories.StockRepository, stockQueue repositories.StockChangeQueue, ttl time.Duration) InventoryService {
+	return inventoryService{stockRepo: stockRepo, stockQueue: stockQueue, ttl: ttl}
+}
+
+func (s inventoryService) Reserve(ctx context.Context, id int, input ReservationInput) (Reservation, error) {
+	if input.Quantity <= 0 {
+		return Reservation{}, ErrInvalidReservation
+	}
+
+	r, err := s.stockRepo.ReserveStock(ctx, id, input.Quantity, s.ttl)
+	if err != nil {
+		return Reservation{}, stockError(err)
+	}
+	return Reservation{
+		ID:        r.ID,
+		ProductID: r.ProductID,
+		Quantity:  r.Quantity,
+		Available: r.Available,
+		ExpiresAt: r.ExpiresAt,
+	}, nil
+}
+
+func (s inventoryService) Release(ctx context.Context, id int, input ReservationInput) error {
+	if input.Reservation == "" {
+		return ErrInvalidReservation
+	}
+	return stockError(s.stockRepo.ReleaseStock(ctx, id, input.Reservation))
+}
+
+func (s inventoryService) Commit(ctx context.Context, id int, input ReservationInput) (Product, error) {
+	if input.Reservation == "" {
+		return Product{}, ErrInvalidReservation
+	}
+
+	p, err := s.stockRepo.CommitStock(ctx, id, input.Reservation)
+	if err != nil {
+		return Product{}, stockError(err)
+	}
+	return newProduct(p), nil
+}
+
+// ChangeStock only queues the change: the product is not looked up, changes
+// of unknown products are dropped when they are flushed.
+func (s inventoryService) ChangeStock(ctx context.Context, id int, input StockChangeInput) (StockChange, error) {
+	if s.stockQueue == nil {
+		return StockChange{}, ErrWriteBehindDisabled
+	}
+	if input.Delta == 0 {
+		return StockChange{}, ErrInvalidStockChange
+	}
+
+	changeID, err := s.stockQueue.EnqueueStockChange(ctx, id, input.Delta)
+	if err != nil {
+		return StockChange{}, err
+	}
+	return StockChange{ID: changeID, ProductID: id, Delta: input.Delta}, nil
+}
+
+//	helper
+
+func stockError(err error) error {
+	switch {
+	case errors.Is(err, repositories.ErrInsufficientStock):
+		return ErrInsufficientStock
+	case errors.Is(err, repositories.ErrReservationNotFound):
+		return ErrReservationNotFound
+	}
+	return repositoryError(err)
+}
@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+//	gorm plugin
+
+const gormSpanKey = "tracing:span"
+
+// GormPlugin records a client span for every statement, a child of the span
+// in the statement context (db.WithContext); register it with
+// db.Use(tracing.GormPlugin{}).
+type GormPlugin struct{}
+
+func (GormPlugin) Name() string {
+	return "tracing"
+}
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	register := []func() error{
+		func() error {
+			return callbacks.Create().Before("gorm:create").Register("tracing:before_create", start("create"))
+		},
+		func() error { return callbacks.Create().After("gorm:create").Register("tracing:after_create", end) },
+		func() error {
+			return callbacks.Query().Before("gorm:query").Register("tracing:before_query", start("query"))
+		},
+		func() error { return callbacks.Query().After("gorm:query").Register("tracing:after_query", end) },
+		func() error {
+			return callbacks.Update().Before("gorm:update").Register("tracing:before_update", start("update"))
+		},
+		func() error { return callbacks.Update().After("gorm:update").Register("tracing:after_update", end) },
+		func() error {
+			return callbacks.Delete().Before("gorm:delete").Register("tracing:before_delete", start("delete"))
+		},
+		func() error { return callbacks.Delete().After("gorm:delete").Register("tracing:after_delete", end) },
+		func() error { return callbacks.Row().Before("gorm:row").Register("tracing:before_row", start("row")) },
+		func() error { return callbacks.Row().After("gorm:row").Register("tracing:after_row", end) },
+		func() error { return callbacks.Raw().Before("gorm:raw").Register("tracing:before_raw", start("raw")) },
+		func() error { return callbacks.Raw().After("gorm:raw").Register("tracing:after_raw", end) },
+	}
+	for _, fn := range register {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func start(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		_, span := otel.Tracer(instrumentation).Start(db.Statement.Context, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemKey.String(db.Dialector.Name()),
+				semconv.DBOperationKey.String(operation),
+			))
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func end(db *gorm.DB) {
+	value, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	span.SetAttributes(
+		semconv.DBSQLTableKey.String(db.Statement.Table),
+		// with placeholders, the values are not recorded
+		semconv.DBStatementKey.String(db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.RowsAffected),
+	)
+	End(span, db.Error, gorm.ErrRecordNotFound)
+}
@@ -0,0 +1,128 @@
+package tracing_test
+
+import (
+	"context"
+	"goredis/handlers"
+	"goredis/repositories"
+	"goredis/services"
+	"goredis/tracing"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanID  = "00f067aa0ba902b7"
+)
+
+func newTestApp(t *testing.T) (*fiber.App, *tracing.Provider) {
+	t.Helper()
+	provider, err := tracing.Setup(context.Background(), tracing.Options{Exporter: tracing.ExporterMemory, SampleRatio: 1, ServiceName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one connection: every connection to :memory: is a new database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+
+	productRepo := repositories.NewProductRepositoryTracing(repositories.NewProductRepositoryDB(context.Background(), db, nil))
+	productService := services.NewCatalogServiceTracing(services.NewCatalogService(productRepo))
+	productHandler := handlers.NewCatalogHandler(productService)
+
+	app := fiber.New()
+	app.Use(tracing.Middleware())
+	app.Get("/products/:id", productHandler.GetProduct)
+	// the spans of the migration
+	provider.Memory.Reset()
+	return app, provider
+}
+
+func TestMiddlewareTraceparent(t *testing.T) {
+	app, provider := newTestApp(t)
+
+	req := httptest.NewRequest("GET", "/products/1", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+spanID+"-01")
+	res, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %v, want 200", res.StatusCode)
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range provider.Memory.GetSpans() {
+		if got := span.SpanContext.TraceID().String(); got != traceID {
+			t.Fatalf("span %v: trace id = %v, want the incoming %v", span.Name, got, traceID)
+		}
+		spans[span.Name] = span
+	}
+
+	// each span is the child of the one before, the first of the caller's
+	chain := []string{"GET /products/:id", "CatalogService.GetProduct", "ProductRepository.GetProduct", "gorm.query"}
+	parent := spanID
+	for _, name := range chain {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("no span %v in %v", name, provider.Memory.GetSpans())
+		}
+		if got := span.Parent.SpanID().String(); got != parent {
+			t.Fatalf("span %v: parent = %v, want %v", name, got, parent)
+		}
+		parent = span.SpanContext.SpanID().String()
+	}
+}
+
+func TestMiddlewareNewTrace(t *testing.T) {
+	app, provider := newTestApp(t)
+
+	res, err := app.Test(httptest.NewRequest("GET", "/products/999999", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusNotFound {
+		t.Fatalf("status = %v, want 404", res.StatusCode)
+	}
+
+	var server *tracetest.SpanStub
+	spans := provider.Memory.GetSpans()
+	for i := range spans {
+		if spans[i].Name == "GET /products/:id" {
+			server = &spans[i]
+		}
+	}
+	if server == nil {
+		t.Fatalf("no server span in %v", spans)
+	}
+	if server.Parent.IsValid() {
+		t.Fatalf("server span has the parent %v, want a new trace", server.Parent.SpanID())
+	}
+	// not found is an answer, not an error
+	for _, span := range spans {
+		if span.Status.Code == codes.Error {
+			t.Fatalf("span %v is an error", span.Name)
+		}
+	}
+}